@@ -6,8 +6,9 @@ import (
 )
 
 type Asset struct {
-	body []byte
-	path string
+	body        []byte
+	path        string
+	contentType string
 }
 
 func NewAsset(path string, res *http.Response) (*Asset, error) {
@@ -17,8 +18,9 @@ func NewAsset(path string, res *http.Response) (*Asset, error) {
 		return nil, err
 	}
 	return &Asset{
-		path: path,
-		body: body,
+		path:        path,
+		body:        body,
+		contentType: res.Header.Get("Content-Type"),
 	}, nil
 }
 
@@ -30,6 +32,12 @@ func (a *Asset) GetPath() string {
 	return a.path
 }
 
+// GetContentType return response content type,
+// assets are classified by it rather than by url extension
+func (a *Asset) GetContentType() string {
+	return a.contentType
+}
+
 func (a *Asset) Free() {
 	a.body = nil
 }
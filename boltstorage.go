@@ -0,0 +1,226 @@
+package crawler
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltProgressBucket = []byte("progress")
+	boltHashesBucket   = []byte("hashes")
+)
+
+var _ ProgressStore = new(BoltStorage)
+
+// BoltStorage keep statuses in embedded bolt database, it is the
+// authoritative set of visited urls so State keeps only in flight urls
+// in memory. Writes are buffered and flushed in batches by size or
+// interval, reads see buffered writes. Buffered writes are lost on
+// crash, so urls changed during the last interval are crawled again
+// on resume.
+type BoltStorage struct {
+	db  *bolt.DB
+	buf *statusBuffer
+
+	mu sync.Mutex
+	// hashes are new content hashes, flushingHashes are being written
+	hashes         map[string]string
+	flushingHashes map[string]string
+}
+
+// NewBoltStorage open or create bolt database at path
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltProgressBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltHashesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	bs := &BoltStorage{
+		db:     db,
+		hashes: make(map[string]string),
+	}
+	bs.buf = newStatusBuffer(statusFlushSize, statusFlushInterval, bs.write)
+	return bs, nil
+}
+
+// Load state from database, only in flight urls are loaded to memory
+func (bs *BoltStorage) Load() (*State, error) {
+	if err := bs.buf.Flush(); err != nil {
+		return nil, err
+	}
+	state := NewState(bs)
+	empty := true
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltProgressBucket).ForEach(func(k, v []byte) error {
+			var rec fileRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			state.AddProgress(string(k), rec.item())
+			empty = false
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	state.SetEmpty(empty)
+	return state, nil
+}
+
+// Clear remove all statuses and return empty state
+func (bs *BoltStorage) Clear() (*State, error) {
+	bs.buf.Reset()
+	bs.mu.Lock()
+	bs.hashes = make(map[string]string)
+	bs.mu.Unlock()
+
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltProgressBucket, boltHashesBucket} {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewState(bs), nil
+}
+
+// SetStatus buffer status of concret url
+func (bs *BoltStorage) SetStatus(url string, i Item) error {
+	bs.buf.Set(url, i)
+	return nil
+}
+
+// Get return item of url
+func (bs *BoltStorage) Get(url string) (Item, bool) {
+	if i, ok := bs.buf.Get(url); ok {
+		return i, true
+	}
+
+	var rec fileRecord
+	var ok bool
+	bs.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltProgressBucket).Get([]byte(url))
+		ok = v != nil && json.Unmarshal(v, &rec) == nil
+		return nil
+	})
+	return rec.item(), ok
+}
+
+// ForEach call fn for all stored items
+func (bs *BoltStorage) ForEach(fn func(url string, i Item)) {
+	if err := bs.buf.Flush(); err != nil {
+		log.Printf("flush statuses error: %s", err)
+	}
+	bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltProgressBucket).ForEach(func(k, v []byte) error {
+			var rec fileRecord
+			if json.Unmarshal(v, &rec) == nil {
+				fn(string(k), rec.item())
+			}
+			return nil
+		})
+	})
+}
+
+// FirstURL return url with content hash, url is set as the first
+// one if hash is new
+func (bs *BoltStorage) FirstURL(hash, url string) string {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if first, ok := bs.hashes[hash]; ok {
+		return first
+	}
+	if first, ok := bs.flushingHashes[hash]; ok {
+		return first
+	}
+
+	var first string
+	bs.db.View(func(tx *bolt.Tx) error {
+		first = string(tx.Bucket(boltHashesBucket).Get([]byte(hash)))
+		return nil
+	})
+	if first == "" {
+		first = url
+		bs.hashes[hash] = url
+	}
+	return first
+}
+
+// Close flush buffered statuses and close database
+func (bs *BoltStorage) Close() error {
+	err := bs.buf.Close()
+	// hashes of urls without status changes
+	if e := bs.write(nil); err == nil {
+		err = e
+	}
+	if e := bs.db.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// write statuses and new hashes in one transaction
+func (bs *BoltStorage) write(items map[string]Item) error {
+	bs.mu.Lock()
+	hashes := bs.hashes
+	if len(items) == 0 && len(hashes) == 0 {
+		bs.mu.Unlock()
+		return nil
+	}
+	bs.flushingHashes = hashes
+	bs.hashes = make(map[string]string)
+	bs.mu.Unlock()
+
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltProgressBucket)
+		for url, i := range items {
+			v, err := json.Marshal(newFileRecord(url, i))
+			if err != nil {
+				return err
+			}
+			if err = b.Put([]byte(url), v); err != nil {
+				return err
+			}
+		}
+		b = tx.Bucket(boltHashesBucket)
+		for hash, url := range hashes {
+			if err := b.Put([]byte(hash), []byte(url)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.flushingHashes = nil
+	if err != nil {
+		for hash, url := range hashes {
+			bs.hashes[hash] = url
+		}
+	}
+	return err
+}
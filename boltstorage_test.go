@@ -0,0 +1,98 @@
+package crawler_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestBoltStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.db")
+
+	bs, err := crawler.NewBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := bs.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.MarkAsInFlight("http://a.com/", crawler.PageType, 0)
+	state.MarkAsInFlight("http://a.com/a", crawler.PageType, 1)
+	state.MarkAsSaved("http://a.com/", crawler.PageType)
+	if err = state.MarkAsInFlight("http://a.com/", crawler.PageType, 0); err == nil {
+		t.Error("expected saved url is visited")
+	}
+	if !state.IsSaved("http://a.com/") || len(state.GetInflight()) != 1 {
+		t.Error("expected saved url in store and in flight url in memory")
+	}
+	state.Close()
+
+	bs, err = crawler.NewBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	state, err = bs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.IsEmpty() || !state.IsSaved("http://a.com/") {
+		t.Error("expected saved url in loaded state")
+	}
+	if _, ok := state.GetInflight()["http://a.com/a"]; !ok {
+		t.Error("expected in flight url in loaded state")
+	}
+}
+
+// BenchmarkState mark urls in flight and saved by parallel workers
+// like upload and save workers of crawler do
+func BenchmarkState(b *testing.B) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b.Run("memory", func(b *testing.B) {
+		benchmarkState(b, crawler.NewState(nil))
+	})
+	b.Run("bolt", func(b *testing.B) {
+		bs, err := crawler.NewBoltStorage(filepath.Join(dir, "state.db"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer bs.Close()
+		state, err := bs.Clear()
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchmarkState(b, state)
+	})
+}
+
+func benchmarkState(b *testing.B, state *crawler.State) {
+	var n int64
+	b.SetParallelism(crawler.DefaultWorkersCount / 4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			url := "http://a.com/" + strconv.FormatInt(atomic.AddInt64(&n, 1), 10)
+			state.MarkAsInFlight(url, crawler.PageType, 1)
+			// links to already visited pages
+			state.MarkAsInFlight("http://a.com/1", crawler.PageType, 1)
+			state.SetResponse(url, 200, "")
+			state.MarkAsSaved(url, crawler.PageType)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package crawler
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Canonicalizer transform url to canonical form,
+// urls with equal canonical form are uploaded once
+type Canonicalizer interface {
+	Canonicalize(u *url.URL) *url.URL
+}
+
+// DefaultTrackingParams is query params which never change page content,
+// trailing * matches any suffix
+var DefaultTrackingParams = []string{
+	"utm_*", "fbclid", "gclid", "yclid", "dclid", "msclkid",
+	"mc_cid", "mc_eid", "_ga", "_hsenc", "_hsmi",
+}
+
+// URLCanonicalizer lowercase host, strip default port and fragment,
+// set "/" for empty path, remove dot segments, filter and sort query params.
+type URLCanonicalizer struct {
+	// DropQuery drop all query params except AllowParams
+	DropQuery bool
+	// AllowParams if not empty only listed params are kept
+	AllowParams []string
+	// DenyParams are always dropped
+	DenyParams []string
+}
+
+// NewURLCanonicalizer return canonicalizer which keeps query
+// and strips tracking params
+func NewURLCanonicalizer() *URLCanonicalizer {
+	return &URLCanonicalizer{
+		DenyParams: DefaultTrackingParams,
+	}
+}
+
+// Canonicalize return canonical copy of u
+func (c *URLCanonicalizer) Canonicalize(u *url.URL) *url.URL {
+	t := *u
+	t.Scheme = strings.ToLower(t.Scheme)
+	t.Host = canonicalHost(t.Scheme, t.Host)
+	t.Fragment = ""
+	if t.Path == "" && t.Opaque == "" {
+		t.Path = "/"
+	}
+	t.Path = removeDotSegments(t.Path)
+	t.RawPath = removeDotSegments(t.RawPath)
+	t.RawQuery = c.canonicalQuery(t.RawQuery)
+	t.ForceQuery = false
+	return &t
+}
+
+// removeDotSegments resolve "." and ".." segments of absolute path,
+// ".." never goes above root
+func removeDotSegments(p string) string {
+	if !strings.HasPrefix(p, "/") || !strings.Contains(p, ".") {
+		return p
+	}
+	segs := strings.Split(p, "/")
+	res := segs[:1]
+	for i, seg := range segs[1:] {
+		last := i == len(segs)-2
+		switch seg {
+		case ".":
+		case "..":
+			if len(res) > 1 {
+				res = res[:len(res)-1]
+			}
+		default:
+			res = append(res, seg)
+			continue
+		}
+		if last {
+			res = append(res, "")
+		}
+	}
+	return strings.Join(res, "/")
+}
+
+func (c *URLCanonicalizer) canonicalQuery(q string) string {
+	if q == "" || (c.DropQuery && len(c.AllowParams) == 0) {
+		return ""
+	}
+
+	var params queryParams
+	for _, p := range strings.FieldsFunc(q, func(r rune) bool { return r == '&' || r == ';' }) {
+		key := p
+		if i := strings.Index(p, "="); i >= 0 {
+			key = p[:i]
+		}
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		if !c.keepParam(key) {
+			continue
+		}
+		params = append(params, queryParam{key, p})
+	}
+
+	sort.Stable(params)
+	raw := make([]string, len(params))
+	for i, p := range params {
+		raw[i] = p.raw
+	}
+	return strings.Join(raw, "&")
+}
+
+type queryParam struct {
+	key string
+	raw string
+}
+
+// queryParams sort params by key, order of equal keys is kept
+type queryParams []queryParam
+
+func (p queryParams) Len() int           { return len(p) }
+func (p queryParams) Less(i, j int) bool { return p[i].key < p[j].key }
+func (p queryParams) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (c *URLCanonicalizer) keepParam(key string) bool {
+	if matchParam(c.DenyParams, key) {
+		return false
+	}
+	if len(c.AllowParams) > 0 {
+		return matchParam(c.AllowParams, key)
+	}
+	return !c.DropQuery
+}
+
+// matchParam return true if key match any of patterns,
+// pattern with trailing * match by prefix
+func matchParam(patterns []string, key string) bool {
+	key = strings.ToLower(key)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(key, p[:len(p)-1]) {
+				return true
+			}
+		} else if p == key {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalHost lowercase host and strip default port of the scheme
+func canonicalHost(scheme, host string) string {
+	host = strings.ToLower(host)
+	h, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		if strings.Contains(h, ":") {
+			return "[" + h + "]"
+		}
+		return h
+	}
+	return host
+}
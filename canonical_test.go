@@ -0,0 +1,43 @@
+package crawler_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestURLCanonicalizer(t *testing.T) {
+	cases := []struct {
+		c        *crawler.URLCanonicalizer
+		url      string
+		expected string
+	}{
+		{crawler.NewURLCanonicalizer(), "HTTP://Example.COM:80", "http://example.com/"},
+		{crawler.NewURLCanonicalizer(), "https://example.com:443/a", "https://example.com/a"},
+		{crawler.NewURLCanonicalizer(), "https://example.com:8443/a", "https://example.com:8443/a"},
+		{crawler.NewURLCanonicalizer(), "http://example.com/a#top", "http://example.com/a"},
+		{crawler.NewURLCanonicalizer(), "http://example.com/a%23b.html", "http://example.com/a%23b.html"},
+		{crawler.NewURLCanonicalizer(), "http://example.com/a/./b/../c.html", "http://example.com/a/c.html"},
+		{crawler.NewURLCanonicalizer(), "http://example.com/a/b/..", "http://example.com/a/"},
+		{crawler.NewURLCanonicalizer(), "http://example.com/a/../../../escaped.html", "http://example.com/escaped.html"},
+		{crawler.NewURLCanonicalizer(), "http://example.com/a..b/.c", "http://example.com/a..b/.c"},
+		{crawler.NewURLCanonicalizer(), "http://example.com/list?page=2", "http://example.com/list?page=2"},
+		{crawler.NewURLCanonicalizer(), "http://example.com/?b=2&a=1&b=1", "http://example.com/?a=1&b=2&b=1"},
+		{crawler.NewURLCanonicalizer(), "http://example.com/?utm_source=x&id=1&fbclid=y", "http://example.com/?id=1"},
+		{crawler.NewURLCanonicalizer(), "http://example.com/?utm_source=x", "http://example.com/"},
+		{&crawler.URLCanonicalizer{DropQuery: true}, "http://example.com/?id=1", "http://example.com/"},
+		{&crawler.URLCanonicalizer{DropQuery: true, AllowParams: []string{"page"}}, "http://example.com/?id=1&page=2", "http://example.com/?page=2"},
+		{&crawler.URLCanonicalizer{DenyParams: []string{"session*"}}, "http://example.com/?sessionid=1&q=a", "http://example.com/?q=a"},
+	}
+
+	for _, c := range cases {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatalf("parse %s error: %s", c.url, err)
+		}
+		if got := c.c.Canonicalize(u).String(); got != c.expected {
+			t.Errorf("canonicalize %s: expected %s, gotten %s", c.url, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestFormatStats(t *testing.T) {
+	cases := []struct {
+		stats    map[string]int64
+		expected string
+	}{
+		{nil, ""},
+		{map[string]int64{"saved": 3}, "saved=3"},
+		{map[string]int64{"saved": 3, "bytes": 100, "failed": 1}, "bytes=100 failed=1 saved=3"},
+	}
+	for _, c := range cases {
+		if got := formatStats(c.stats); got != c.expected {
+			t.Errorf("format %v: expected %q, gotten %q", c.stats, c.expected, got)
+		}
+	}
+}
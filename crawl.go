@@ -2,16 +2,19 @@ package crawler
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,14 +24,14 @@ const (
 
 var (
 	errAnotherDomain = errors.New("another domain")
-	errIsMailTo      = errors.New("is mailto")
-	errIsAnchor      = errors.New("is anchor")
+	errNotHTTP       = errors.New("not http scheme")
 )
 
 type File interface {
 	GetPath() string
 	GetBody() []byte
 	GetType() ItemType
+	GetContentType() string
 	Free()
 }
 
@@ -40,14 +43,86 @@ type Crawler struct {
 	SaveWorkers       int
 	EnableGzip        bool
 	IncludeSubDomains bool
+	UserAgent         string
+	RespectRobots     bool
+
+	// AllowedHosts is hosts and *.domain wildcards crawled in addition
+	// to the main host
+	AllowedHosts []string
+	// Seeds is start urls in addition to the main endpoint,
+	// hosts of seeds are added to the crawl scope
+	Seeds []string
+	// UseSitemaps enqueue urls from sitemaps of seeds hosts
+	UseSitemaps bool
+
+	// AssetHosts is hosts and *.domain wildcards from which assets
+	// required by pages are uploaded, "*" allows any host
+	AssetHosts []string
+
+	// MaxRequestsPerSecond and MaxConnsPerHost limit load on single host,
+	// zero disables the limit
+	MaxRequestsPerSecond float64
+	MaxConnsPerHost      int
+
+	// MaxDepth, MaxPages, MaxAssets and MaxBytes bound the crawl,
+	// zero disables the limit
+	MaxDepth  int
+	MaxPages  int
+	MaxAssets int
+	MaxBytes  int64
+
+	Retry RetryPolicy
+
+	// ErrorsOutput is path for bodies of non-2xx responses,
+	// empty value disables saving of error responses
+	ErrorsOutput string
+
+	// ConvertLinks rewrite links in saved pages for offline browsing,
+	// links point to .gz files of gzipped directory output
+	ConvertLinks bool
+
+	// Canonicalizer transform urls to canonical form before dedup
+	Canonicalizer Canonicalizer
+
+	// Filter include and exclude urls, nil allows all urls
+	Filter *URLFilter
+
+	// Sink store saved files, nil is directory tree in output,
+	// nil sink with empty output disables files storing
+	Sink Sink
+
+	// WARC archive responses in addition to sink,
+	// it is closed with crawler
+	WARC *WARCWriter
+
+	// Incremental revisit urls saved by previous run with conditional
+	// requests, unchanged files are not rewritten
+	Incremental bool
+
+	// Dedup write identical bodies once, other urls with the same content
+	// are hardlinks to the first file and links of them are not followed.
+	// Pages with converted links are written separately because their
+	// links depend on location.
+	Dedup bool
 
 	uploadPageCh  chan string
 	uploadAssetCh chan string
 	saveCh        chan File
 
+	ctx        context.Context
+	uploadWg   sync.WaitGroup
+	saveWg     sync.WaitGroup
 	mainURL    *url.URL
+	scope      *Scope
+	assetScope *Scope
 	state      *State
 	httpClient *http.Client
+	scheduler  *hostScheduler
+
+	robots   map[string]*robotsEntry
+	robotsMu sync.Mutex
+
+	errorsSink Sink
 }
 
 // New return new Crawler instance
@@ -59,27 +134,44 @@ func New(h, o string, s *State) (*Crawler, error) {
 	if m.Host == "" {
 		return nil, errors.New("empty main host")
 	}
+	sch := newHostScheduler(&http.Transport{
+		Dial: (&net.Dialer{
+			Timeout:   15 * time.Second,
+			KeepAlive: 180 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConnsPerHost:   DefaultHostConns,
+	})
 	return &Crawler{
-		endpoint:          h,
-		mainURL:           m,
-		output:            o,
-		uploadPageCh:      make(chan string, 1024),
-		uploadAssetCh:     make(chan string, 1024),
-		saveCh:            make(chan File, 128),
-		UploadWorkers:     DefaultWorkersCount,
-		SaveWorkers:       DefaultWorkersCount,
-		IncludeSubDomains: false,
-		EnableGzip:        true,
-		state:             s,
+		endpoint:             h,
+		mainURL:              m,
+		scope:                NewScope(canonicalHost(m.Scheme, m.Host), false),
+		assetScope:           NewScope(canonicalHost(m.Scheme, m.Host), false),
+		output:               o,
+		uploadPageCh:         make(chan string, 1024),
+		uploadAssetCh:        make(chan string, 1024),
+		saveCh:               make(chan File, 128),
+		UploadWorkers:        DefaultWorkersCount,
+		SaveWorkers:          DefaultWorkersCount,
+		IncludeSubDomains:    false,
+		EnableGzip:           true,
+		UserAgent:            DefaultUserAgent,
+		RespectRobots:        true,
+		MaxRequestsPerSecond: DefaultHostRPS,
+		MaxConnsPerHost:      DefaultHostConns,
+		Retry:                DefaultRetryPolicy,
+		Canonicalizer:        NewURLCanonicalizer(),
+		ctx:                  context.Background(),
+		state:                s,
+		robots:               make(map[string]*robotsEntry),
+		scheduler:            sch,
 		httpClient: &http.Client{
-			Transport: &http.Transport{
-				Dial: (&net.Dialer{
-					Timeout:   15 * time.Second,
-					KeepAlive: 180 * time.Second,
-				}).Dial,
-				TLSHandshakeTimeout:   10 * time.Second,
-				ResponseHeaderTimeout: 10 * time.Second,
-				ExpectContinueTimeout: 1 * time.Second,
+			Transport: sch,
+			// redirects are followed by crawler to record and dedup them
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
 			},
 		},
 	}, nil
@@ -87,53 +179,175 @@ func New(h, o string, s *State) (*Crawler, error) {
 
 // Run crawler proccess
 func (c *Crawler) Run() {
+	c.RunContext(context.Background())
+}
+
+// RunContext run crawler proccess until all urls are processed or ctx is done.
+// On cancellation in-flight requests are aborted, already uploaded files
+// are saved and unfinished urls stay in flight in the storage for resume.
+func (c *Crawler) RunContext(ctx context.Context) {
+	c.ctx = ctx
+	seeds := append([]string{c.endpoint}, c.Seeds...)
+	hosts := append([]string{}, c.AllowedHosts...)
+	for _, s := range c.Seeds {
+		if u, err := url.Parse(s); err == nil {
+			hosts = append(hosts, canonicalHost(u.Scheme, u.Host))
+		}
+	}
+	mainHost := canonicalHost(c.mainURL.Scheme, c.mainURL.Host)
+	c.scope = NewScope(mainHost, c.IncludeSubDomains, hosts...)
+	c.assetScope = NewScope(mainHost, c.IncludeSubDomains, append(hosts, c.AssetHosts...)...)
+	c.scheduler.configure(c.MaxRequestsPerSecond, c.MaxConnsPerHost)
+	if c.Sink == nil && c.output != "" {
+		c.Sink = NewDirSink(c.output, c.EnableGzip)
+	}
+	if c.ErrorsOutput != "" {
+		c.errorsSink = NewDirSink(c.ErrorsOutput, c.EnableGzip)
+	}
+	c.state.SetLimit(PageType, c.MaxPages)
+	c.state.SetLimit(AssetType, c.MaxAssets)
 	c.runWorkers()
 
-	// if state empty start from main url
+	// if state empty start from seeds
 	if c.state.IsEmpty() {
-		c.enqueUploadPage(c.endpoint)
+		for _, seed := range seeds {
+			u, err := c.normalizeURL(seed, c.scope)
+			if err != nil {
+				log.Printf("normalize seed %s error: %s", seed, err)
+				continue
+			}
+			c.enqueUploadPage(u, "", 0)
+		}
+		if c.UseSitemaps {
+			for _, seed := range seeds {
+				c.enqueSitemaps(seed)
+			}
+		}
 	} else {
+		if c.Incremental {
+			log.Printf("revisit %d saved urls", c.state.Revisit())
+		}
 		for url, i := range c.state.GetInflight() {
 			switch i.itype {
 			case PageType:
-				go func(u string) { c.uploadPageCh <- u }(url)
+				go c.push(c.uploadPageCh, url)
 			case AssetType:
-				go func(u string) { c.uploadAssetCh <- u }(url)
+				go c.push(c.uploadAssetCh, url)
 			default:
 				log.Printf("undefined type: %d from state", i.itype)
 			}
 		}
+		// limits could be raised since previous run
+		for url, i := range c.state.GetLimited() {
+			if i.itype == AssetType {
+				c.enqueUploadAsset(url, i.parent, i.depth)
+			} else {
+				c.enqueUploadPage(url, i.parent, i.depth)
+			}
+		}
+		if c.Incremental {
+			// seeds could be added or failed in previous run
+			for _, seed := range seeds {
+				if u, err := c.normalizeURL(seed, c.scope); err == nil {
+					c.enqueUploadPage(u, "", 0)
+				}
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.state.WaiteAll()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("crawler canceled: %s, waiting for in-flight saves", ctx.Err())
+		c.uploadWg.Wait()
+		c.saveWg.Wait()
+	}
+}
+
+// push url to upload channel unless crawler is canceled
+func (c *Crawler) push(ch chan string, url string) {
+	select {
+	case ch <- url:
+	case <-c.ctx.Done():
 	}
-	c.state.WaiteAll()
 }
 
-func (c *Crawler) enqueUploadPage(url string) {
-	if err := c.state.MarkAsInFlight(url, PageType); err != nil {
-		if err != errHasMoreOrEqualStatus {
+func (c *Crawler) isCanceled() bool {
+	return c.ctx.Err() != nil
+}
+
+// enqueUploadPage with depth and url of the page where url is found
+func (c *Crawler) enqueUploadPage(url, parent string, depth int) {
+	if !c.Filter.Allow(url, "") {
+		c.state.MarkAsFiltered(url, PageType, depth)
+		return
+	}
+	if !c.isAllowedByRobots(url) {
+		c.state.MarkAsDisallowed(url, PageType, depth)
+		return
+	}
+	if (c.MaxDepth > 0 && depth > c.MaxDepth) || c.isBytesLimitReached() {
+		c.state.MarkAsLimited(url, PageType, depth)
+		return
+	}
+	if err := c.state.MarkAsInFlight(url, PageType, depth); err != nil {
+		if err == errLimitReached {
+			c.state.MarkAsLimited(url, PageType, depth)
+		} else if err != errHasMoreOrEqualStatus {
 			log.Printf("enqueUploadPage, mark as in flight error: %s", err)
 		}
 		return
 	}
-	go func() { c.uploadPageCh <- url }()
+	c.state.SetParent(url, parent)
+	go c.push(c.uploadPageCh, url)
 }
 
-func (c *Crawler) enqueUploadAsset(url string) {
-	if err := c.state.MarkAsInFlight(url, AssetType); err != nil {
-		if err != errHasMoreOrEqualStatus {
+// enqueUploadAsset with depth of the page which requires the asset,
+// depth limit is not applied to assets
+func (c *Crawler) enqueUploadAsset(url, parent string, depth int) {
+	if !c.Filter.Allow(url, "") {
+		c.state.MarkAsFiltered(url, AssetType, depth)
+		return
+	}
+	if !c.isAllowedByRobots(url) {
+		c.state.MarkAsDisallowed(url, AssetType, depth)
+		return
+	}
+	if c.isBytesLimitReached() {
+		c.state.MarkAsLimited(url, AssetType, depth)
+		return
+	}
+	if err := c.state.MarkAsInFlight(url, AssetType, depth); err != nil {
+		if err == errLimitReached {
+			c.state.MarkAsLimited(url, AssetType, depth)
+		} else if err != errHasMoreOrEqualStatus {
 			log.Printf("enqueUploadAsset, mark as in flight error: %s", err)
 		}
 		return
 	}
-	go func() { c.uploadAssetCh <- url }()
+	c.state.SetParent(url, parent)
+	go c.push(c.uploadAssetCh, url)
+}
+
+func (c *Crawler) isBytesLimitReached() bool {
+	return c.MaxBytes > 0 && c.state.Bytes() >= c.MaxBytes
 }
 
 func (c *Crawler) enqueSave(f File) {
 	if !c.state.IsSaved(f.GetPath()) {
+		c.saveWg.Add(1)
 		go func() { c.saveCh <- f }()
 	}
 }
 
 func (c *Crawler) runWorkers() {
+	c.uploadWg.Add(2 * c.UploadWorkers)
 	for i := 0; i < c.UploadWorkers; i++ {
 		go c.serveUploadPage()
 		go c.serveUploadAsset()
@@ -152,175 +366,392 @@ func (c *Crawler) Close() {
 	if err != nil {
 		log.Printf("close state error: %s", err)
 	}
+	if c.Sink != nil {
+		if err = c.Sink.Close(); err != nil {
+			log.Printf("close sink error: %s", err)
+		}
+	}
+	if c.errorsSink != nil {
+		if err = c.errorsSink.Close(); err != nil {
+			log.Printf("close errors sink error: %s", err)
+		}
+	}
+	if c.WARC != nil {
+		if err = c.WARC.Close(); err != nil {
+			log.Printf("close warc error: %s", err)
+		}
+	}
 }
 
 func (c *Crawler) serveUploadPage() {
+	defer c.uploadWg.Done()
 	for {
-		url := <-c.uploadPageCh
+		var url string
+		select {
+		case url = <-c.uploadPageCh:
+		case <-c.ctx.Done():
+			return
+		}
 		if url == "" {
 			return
 		}
 
-		req, err := craeteRequest(url)
-		if err != nil {
-			log.Printf("create request error: %s", err)
-			c.state.MarkAsIgnored(url, PageType)
+		res := c.fetch(url, PageType)
+		if res == nil {
 			continue
 		}
 
-		res, err := c.httpClient.Do(req)
+		page, err := NewPage(url, res)
 		if err != nil {
-			log.Printf("http get: %s, error: %s", url, err)
-			c.state.MarkAsIgnored(url, PageType)
+			if !c.isCanceled() {
+				log.Printf("create page error: %s", err)
+				c.fail(url, PageType, err)
+			}
 			continue
 		}
+		c.state.AddBytes(len(page.GetBody()))
+		c.state.SetContent(url, page.GetContentType(), len(page.GetBody()))
+		c.archive(res, page.GetBody(), append(page.Pages, page.Assets...))
 
-		page, err := NewPage(url, res)
-		if err != nil {
-			log.Printf("create page error: %s", err)
-			c.state.MarkAsIgnored(url, PageType)
-			continue
+		// links of error pages and duplicates are not followed
+		if isSuccessStatus(res.StatusCode) && !c.isDuplicate(page) {
+			c.enqueLinks(page, c.state.Depth(url)+1)
 		}
+		c.enqueSave(page)
+	}
+}
 
-		for _, purl := range page.Pages {
-			u, err := c.normalizeURL(purl)
-			if err != nil {
-				if err != errAnotherDomain && err != errIsMailTo && err != errIsAnchor {
-					log.Printf("fail normilize url: %s error: %s", purl, err)
-				}
-				continue
+func (c *Crawler) enqueLinks(page *Page, depth int) {
+	for _, purl := range page.Pages {
+		u, err := c.normalizeURL(purl, c.scope)
+		if err != nil {
+			if err != errAnotherDomain && err != errNotHTTP {
+				log.Printf("fail normilize url: %s error: %s", purl, err)
 			}
-			c.enqueUploadPage(u)
+			continue
 		}
+		c.enqueUploadPage(u, page.GetPath(), depth)
+	}
 
-		for _, aurl := range page.Assets {
-			u, err := c.normalizeURL(aurl)
-			if err != nil {
-				if err != errAnotherDomain {
-					log.Printf("fail normilize url: %s error: %s", aurl, err)
-				}
-				continue
+	for _, aurl := range page.Assets {
+		u, err := c.normalizeURL(aurl, c.assetScope)
+		if err != nil {
+			if err != errAnotherDomain && err != errNotHTTP {
+				log.Printf("fail normilize url: %s error: %s", aurl, err)
 			}
-			c.enqueUploadAsset(u)
+			continue
 		}
-
-		c.enqueSave(page)
+		c.enqueUploadAsset(u, page.GetPath(), depth)
 	}
 }
 
 func (c *Crawler) serveUploadAsset() {
+	defer c.uploadWg.Done()
 	for {
-		url := <-c.uploadAssetCh
+		var url string
+		select {
+		case url = <-c.uploadAssetCh:
+		case <-c.ctx.Done():
+			return
+		}
 		if url == "" {
 			return
 		}
 
-		res, err := http.Get(url)
-		if err != nil {
-			log.Printf("http get: %s, error: %s", url, err)
-			c.state.MarkAsIgnored(url, AssetType)
+		res := c.fetch(url, AssetType)
+		if res == nil {
 			continue
 		}
 
 		asset, err := NewAsset(url, res)
 		if err != nil {
-			log.Printf("create asset error: %s", err)
-			c.state.MarkAsIgnored(url, AssetType)
+			if !c.isCanceled() {
+				log.Printf("create asset error: %s", err)
+				c.fail(url, AssetType, err)
+			}
 			continue
 		}
+		c.state.AddBytes(len(asset.GetBody()))
+		c.state.SetContent(url, asset.GetContentType(), len(asset.GetBody()))
+		c.archive(res, asset.GetBody(), nil)
 
+		if isSuccessStatus(res.StatusCode) && !c.isDuplicate(asset) && isCSS(asset.GetContentType()) {
+			c.enqueCSSDependencies(asset, c.state.Depth(url)+1)
+		}
 		c.enqueSave(asset)
 	}
 }
 
+// isDuplicate record content hash of file and return true if another url
+// with the same content is already processed and dedup is enabled
+func (c *Crawler) isDuplicate(f File) bool {
+	orig := c.state.SetHash(f.GetPath(), contentHash(f.GetBody()))
+	return c.Dedup && orig != ""
+}
+
+func contentHash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// fetch url and return response which body should be saved.
+// Return nil if url is already processed: redirected, failed,
+// scheduled for retry or crawler is canceled.
+func (c *Crawler) fetch(url string, t ItemType) *http.Response {
+	req, err := c.craeteRequest(url)
+	if err != nil {
+		log.Printf("create request error: %s", err)
+		c.state.MarkAsIgnored(url, t)
+		return nil
+	}
+	conditional := c.Incremental && c.setConditions(req, url)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		if !c.isCanceled() {
+			log.Printf("http get: %s, error: %s", url, err)
+			c.fail(url, t, err)
+		}
+		return nil
+	}
+	if c.Retry.isRetryableStatus(res.StatusCode) {
+		res.Body.Close()
+		c.fail(url, t, statusError{res.StatusCode})
+		return nil
+	}
+
+	if conditional && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		c.state.SetResponse(url, res.StatusCode, "")
+		c.state.MarkAsSaved(url, t)
+		return nil
+	}
+	if isRedirectStatus(res.StatusCode) {
+		c.archive(res, nil, nil)
+		res.Body.Close()
+		c.redirect(url, t, res)
+		return nil
+	}
+
+	c.state.SetResponse(url, res.StatusCode, "")
+	if isSuccessStatus(res.StatusCode) {
+		c.state.SetValidators(url, res.Header.Get("ETag"), res.Header.Get("Last-Modified"))
+	}
+	if c.Filter.HasMIMERules() && !c.Filter.Allow(url, res.Header.Get("Content-Type")) {
+		res.Body.Close()
+		c.state.MarkAsFiltered(url, t, 0)
+		return nil
+	}
+	if !isSuccessStatus(res.StatusCode) && c.ErrorsOutput == "" {
+		c.archive(res, nil, nil)
+		res.Body.Close()
+		c.state.MarkAsFailed(url, t)
+		return nil
+	}
+	return res
+}
+
+// archive write response to WARC if it is enabled,
+// if body is nil it is read from response
+func (c *Crawler) archive(res *http.Response, body []byte, links []string) {
+	if body == nil {
+		body, _ = ioutil.ReadAll(res.Body)
+	}
+	if c.WARC == nil {
+		return
+	}
+	if err := c.WARC.WriteResponse(res, body, links); err != nil {
+		log.Printf("write warc record for %s error: %s", res.Request.URL, err)
+	}
+}
+
+// setConditions add If-None-Match and If-Modified-Since headers from
+// previous response if its file exists, return true if any header added
+func (c *Crawler) setConditions(req *http.Request, url string) bool {
+	etag, modified := c.state.Validators(url)
+	if etag == "" && modified == "" {
+		return false
+	}
+	fc, ok := c.Sink.(fileChecker)
+	if !ok {
+		return false
+	}
+	name, err := c.getOutputFileNameByURL(url)
+	if err != nil || !fc.Exists(name) {
+		return false
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if modified != "" {
+		req.Header.Set("If-Modified-Since", modified)
+	}
+	return true
+}
+
+// redirect record redirect location and enqueue it with the same depth
+func (c *Crawler) redirect(url string, t ItemType, res *http.Response) {
+	loc, err := res.Location()
+	if err != nil {
+		log.Printf("redirect from %s error: %s", url, err)
+		c.state.SetResponse(url, res.StatusCode, "")
+		c.state.MarkAsFailed(url, t)
+		return
+	}
+
+	scope := c.scope
+	if t == AssetType {
+		scope = c.assetScope
+	}
+	target, err := c.normalizeURL(loc.String(), scope)
+	if err != nil {
+		if err != errAnotherDomain && err != errNotHTTP {
+			log.Printf("fail normilize redirect url: %s error: %s", loc, err)
+		}
+		c.state.SetResponse(url, res.StatusCode, loc.String())
+		c.state.MarkAsRedirected(url, t)
+		return
+	}
+
+	c.state.SetResponse(url, res.StatusCode, target)
+	depth := c.state.Depth(url)
+	if t == PageType {
+		c.enqueUploadPage(target, url, depth)
+	} else {
+		c.enqueUploadAsset(target, url, depth)
+	}
+	c.state.MarkAsRedirected(url, t)
+}
+
+func isSuccessStatus(code int) bool {
+	return code >= 200 && code < 300
+}
+
+func isRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, 308:
+		return true
+	}
+	return false
+}
+
 func (c *Crawler) serveSave() {
 	for {
 		f := <-c.saveCh
-		name, err := c.getOutputFileNameByURL(f.GetPath())
-		if err != nil {
-			log.Printf("get file name for url: %s, error: %s", f.GetPath(), err)
-			c.state.MarkAsIgnored(f.GetPath(), f.GetType())
-			f.Free()
-			continue
+		if f == nil {
+			return
 		}
-		path := c.output + name
+		c.save(f)
+		f.Free()
+		c.saveWg.Done()
+	}
+}
 
-		// create dir if not exists
-		dir, _ := filepath.Split(path)
-		if _, err := os.Stat(dir); os.IsNotExist(err) {
-			err = os.MkdirAll(dir, 0744)
-			if err != nil {
-				log.Printf("craete dir: %s error: %s", dir, err)
-				c.state.MarkAsIgnored(f.GetPath(), f.GetType())
-				f.Free()
-				continue
-			}
-		}
+func (c *Crawler) save(f File) {
+	failed := !isSuccessStatus(c.state.HTTPStatus(f.GetPath()))
+	sink := c.Sink
+	if failed {
+		sink = c.errorsSink
+	}
+	// files are not stored, response is only archived
+	if sink == nil {
+		c.markAsProcessed(f, failed)
+		return
+	}
 
-		if c.EnableGzip {
-			path += ".gz"
-			var b bytes.Buffer
-			w := gzip.NewWriter(&b)
-			w.Write(f.GetBody())
-			w.Close()
-			err = ioutil.WriteFile(path, b.Bytes(), 0666)
-		} else {
-			err = ioutil.WriteFile(path, f.GetBody(), 0666)
-		}
+	name, err := c.getOutputFileNameByURL(f.GetPath())
+	if err != nil {
+		log.Printf("get file name for url: %s, error: %s", f.GetPath(), err)
+		c.state.MarkAsIgnored(f.GetPath(), f.GetType())
+		return
+	}
+	m := Meta{
+		Name:        name,
+		StatusCode:  c.state.HTTPStatus(f.GetPath()),
+		ContentType: f.GetContentType(),
+	}
+	m.ETag, m.LastModified = c.state.Validators(f.GetPath())
 
+	p, isPage := f.(*Page)
+	if c.Dedup && !failed && !(isPage && c.ConvertLinks) {
+		m.Hash = c.state.Hash(f.GetPath())
+	}
+	if isPage && c.ConvertLinks && !failed {
+		b, err := c.rewriteLinks(p)
 		if err != nil {
-			log.Printf("wrte file error: %s", err)
+			log.Printf("rewrite links in %s error: %s", f.GetPath(), err)
+		} else {
+			f = &convertedFile{File: p, body: b}
 		}
+	}
 
+	if err = sink.Write(f, m); err != nil {
+		log.Printf("wrte file error: %s", err)
+	}
+	c.markAsProcessed(f, failed)
+}
+
+func (c *Crawler) markAsProcessed(f File, failed bool) {
+	if failed {
+		c.state.MarkAsFailed(f.GetPath(), f.GetType())
+	} else {
 		c.state.MarkAsSaved(f.GetPath(), f.GetType())
-		f.Free()
 	}
 }
 
-// todo add cancelation context
-func craeteRequest(url string) (*http.Request, error) {
+// craeteRequest return GET request bound to crawler context
+func (c *Crawler) craeteRequest(url string) (*http.Request, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(c.ctx)
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
 	return req, err
 }
 
-func (c *Crawler) normalizeURL(u string) (string, error) {
-	u = strings.TrimSpace(strings.Trim(u, "\n"))
-	if strings.Contains(u, "mailto") {
-		return "", errIsMailTo
+// resolveURL resolve reference relative to base url,
+// reference is returned as is if any of urls is invalid
+func resolveURL(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
 	}
-
-	if strings.Contains(u, "#") {
-		return "", errIsAnchor
+	r, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
 	}
+	return b.ResolveReference(r).String()
+}
 
-	t, err := url.Parse(u)
+// normalizeURL return canonical form of http url in scope s
+func (c *Crawler) normalizeURL(u string, s *Scope) (string, error) {
+	t, err := url.Parse(strings.TrimSpace(u))
 	if err != nil {
 		return "", err
 	}
 
-	if t.Host == "" {
+	if t.Host == "" && t.Opaque == "" {
 		t.Host = c.mainURL.Host
 		t.Scheme = c.mainURL.Scheme
 	}
 	if t.Scheme == "" {
 		t.Scheme = c.mainURL.Scheme
 	}
+	// mailto:, data:, javascript:, tel: and other non-fetchable links
+	if t.Scheme != "http" && t.Scheme != "https" {
+		return "", errNotHTTP
+	}
 
-	// check doamin
-	if c.IncludeSubDomains {
-		if !strings.Contains(t.Host, c.mainURL.Host) {
-			return "", errAnotherDomain
-		}
-	} else {
-		if t.Host != c.mainURL.Host {
-			return "", errAnotherDomain
-		}
+	t = c.Canonicalizer.Canonicalize(t)
+	if !s.Contains(t.Host) {
+		return "", errAnotherDomain
 	}
 
-	t.RawQuery = ""
 	return t.String(), nil
 }
 
@@ -334,18 +765,47 @@ func (c *Crawler) getOutputFileNameByURL(u string) (string, error) {
 		t.Host = c.mainURL.Host
 	}
 
-	if t.Path == "" {
-		return t.Host + "/index.html", nil
-	}
-
 	name := t.Host + t.Path
 	_, file := filepath.Split(t.Path)
-	if file == "" || !strings.Contains(file, ".") || file == t.Host {
+	switch {
+	case t.Path == "":
+		name = t.Host + "/index.html"
+	case file == "" || !strings.Contains(file, ".") || file == t.Host:
 		if t.Path[len(t.Path)-1] == '/' {
-			return name + "index.html", nil
+			name += "index.html"
+		} else {
+			name += "/index.html"
 		}
-		return name + "/index.html", nil
 	}
 
-	return name, nil
+	return withQuery(name, t.RawQuery), nil
+}
+
+// withQuery encode url query into file name before extension,
+// so pages which differ by query only are saved to distinct files:
+// list/index.html with page=2 query become list/index@page=2.html
+func withQuery(name, query string) string {
+	if query == "" {
+		return name
+	}
+
+	var b bytes.Buffer
+	b.WriteByte('@')
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if isFileNameChar(ch) {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+
+	dir, file := filepath.Split(name)
+	ext := filepath.Ext(file)
+	return dir + strings.TrimSuffix(file, ext) + b.String() + ext
+}
+
+func isFileNameChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '=' || c == '&' || c == '-' || c == '_' || c == '.' || c == '+' || c == ','
 }
@@ -0,0 +1,293 @@
+package crawler_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chapsuk/crawler"
+)
+
+// testCrawl is crawl of test server to temp output directory
+type testCrawl struct {
+	t   *testing.T
+	srv *httptest.Server
+	dir string
+	// state is shared by crawls of the test
+	state *crawler.State
+}
+
+// newTestCrawl start test server with handler, close must be deferred
+func newTestCrawl(t *testing.T, h http.HandlerFunc) *testCrawl {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCrawl{
+		t:     t,
+		srv:   httptest.NewServer(h),
+		dir:   dir,
+		state: crawler.NewState(nil),
+	}
+}
+
+func (tc *testCrawl) close() {
+	tc.srv.Close()
+	os.RemoveAll(tc.dir)
+}
+
+// url return absolute url of path on test server
+func (tc *testCrawl) url(path string) string {
+	return tc.srv.URL + path
+}
+
+// crawler return crawler of test server with state of test
+func (tc *testCrawl) crawler() *crawler.Crawler {
+	c, err := crawler.New(tc.srv.URL, tc.dir+"/out/", tc.state)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	return c
+}
+
+// run crawl with options set by opts
+func (tc *testCrawl) run(opts func(c *crawler.Crawler)) *crawler.Crawler {
+	c := tc.crawler()
+	if opts != nil {
+		opts(c)
+	}
+	c.Run()
+	c.Close()
+	return c
+}
+
+// path return output path of file name of test server host
+func (tc *testCrawl) path(name string) string {
+	return tc.dir + "/out/" + tc.srv.Listener.Addr().String() + name
+}
+
+// read return content of output file, .gz files are decompressed
+func (tc *testCrawl) read(name string) string {
+	b, err := ioutil.ReadFile(tc.path(name))
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			tc.t.Fatal(err)
+		}
+		if b, err = ioutil.ReadAll(gz); err != nil {
+			tc.t.Fatal(err)
+		}
+	}
+	return string(b)
+}
+
+func TestIncrementalCrawl(t *testing.T) {
+	pages := map[string]string{
+		"/":  `<a href="/a">a</a><a href="/b">b</a><a href="/d">d</a>`,
+		"/a": `a`,
+		"/b": `b`,
+		"/d": `d`,
+	}
+	// etag of /d changes every run while its content is the same
+	run := 0
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		etag := fmt.Sprintf(`"%x"`, len(body))
+		if r.URL.Path == "/d" {
+			etag = fmt.Sprintf(`"%x-%d"`, len(body), run)
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, "<html><body>"+body+"</body></html>")
+	})
+	defer tc.close()
+
+	crawl := func() {
+		run++
+		tc.run(func(c *crawler.Crawler) { c.Incremental = true })
+	}
+	crawl()
+	if ch := tc.state.Changes(); ch != (crawler.Changes{New: 4}) {
+		t.Fatalf("unexpected first run changes: %+v", ch)
+	}
+
+	pages["/a"] = `changed <a href="/c">c</a>`
+	pages["/c"] = `c`
+	delete(pages, "/b")
+	tc.state.SetEmpty(false)
+	crawl()
+	expected := crawler.Changes{New: 1, Changed: 1, Unchanged: 2, Removed: 1}
+	if ch := tc.state.Changes(); ch != expected {
+		t.Errorf("expected %+v, gotten %+v", expected, ch)
+	}
+}
+
+func TestDedupCrawl(t *testing.T) {
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/b">b</a></body></html>`)
+		case "/a", "/b":
+			fmt.Fprint(w, `<html><body>same</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer tc.close()
+
+	tc.run(func(c *crawler.Crawler) { c.Dedup = true })
+
+	a, err := os.Stat(tc.path("/a/index.html.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(tc.path("/b/index.html.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Error("expected duplicates are hardlinks")
+	}
+}
+
+// TestCancelCrawl check canceled crawl drains saves of fetched pages
+// and leaves pending urls in flight for resume
+func TestCancelCrawl(t *testing.T) {
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/b">b</a></body></html>`)
+		case "/a", "/b":
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer tc.close()
+
+	c := tc.crawler()
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.RunContext(ctx)
+		close(done)
+	}()
+	for !tc.state.IsSaved(tc.url("/")) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("canceled crawl is not stopped")
+	}
+
+	if _, err := os.Stat(tc.path("/index.html.gz")); err != nil {
+		t.Error(err)
+	}
+	inflight := tc.state.GetInflight()
+	for _, path := range []string{"/a", "/b"} {
+		if _, ok := inflight[tc.url(path)]; !ok {
+			t.Errorf("expected %s in flight, gotten %v", path, inflight)
+		}
+	}
+}
+
+// failStorage is storage which never stores statuses
+type failStorage struct{}
+
+func (failStorage) Load() (*crawler.State, error)              { return nil, errors.New("load error") }
+func (failStorage) SetStatus(url string, i crawler.Item) error { return errors.New("store error") }
+func (failStorage) Close() error                               { return nil }
+
+func TestCrawlStorageError(t *testing.T) {
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/a">a</a></body></html>`)
+		case "/a":
+			fmt.Fprint(w, `<html><body>a</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer tc.close()
+
+	tc.state = crawler.NewState(failStorage{})
+	c := tc.crawler()
+	defer c.Close()
+	done := make(chan struct{})
+	go func() {
+		c.Run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawl with failed storage is not finished")
+	}
+	if !tc.state.IsSaved(tc.url("/a")) {
+		t.Error("expected page saved in spite of storage errors")
+	}
+}
+
+// TestDepthLimit check url found beyond max depth is crawled when it is
+// found again within limit or limit is raised on resume
+func TestDepthLimit(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/slow">slow</a></body></html>`)
+		case "/a":
+			fmt.Fprint(w, `<html><body><a href="/b">b</a></body></html>`)
+		case "/b":
+			fmt.Fprint(w, `<html><body><a href="/x">x</a><a href="/y">y</a></body></html>`)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprint(w, `<html><body><a href="/x">x</a></body></html>`)
+		case "/x", "/y":
+			fmt.Fprint(w, `<html><body>leaf</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer tc.close()
+
+	tc.run(func(c *crawler.Crawler) { c.MaxDepth = 2 })
+	if !requested["/x"] || requested["/y"] {
+		t.Fatalf("expected /x found within limit is requested and /y is not, gotten %v", requested)
+	}
+
+	tc.state.SetEmpty(false)
+	tc.run(func(c *crawler.Crawler) { c.MaxDepth = 3 })
+	if !requested["/y"] || !tc.state.IsSaved(tc.url("/y")) {
+		t.Error("expected limited url is crawled after limit is raised")
+	}
+}
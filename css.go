@@ -0,0 +1,143 @@
+package crawler
+
+import (
+	"log"
+	"mime"
+	"strings"
+)
+
+// ParseCSS return url(...) and @import targets of stylesheet,
+// comments and strings outside of them are skipped
+func ParseCSS(css string) (urls []string) {
+	add := func(u string) {
+		u = strings.TrimSpace(u)
+		if u == "" || u[0] == '#' || strings.HasPrefix(strings.ToLower(u), "data:") {
+			return
+		}
+		urls = append(urls, u)
+	}
+
+	for i := 0; i < len(css); {
+		switch {
+		case strings.HasPrefix(css[i:], "/*"):
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				return
+			}
+			i += end + 4
+		case css[i] == '"' || css[i] == '\'':
+			_, n := readCSSString(css[i:])
+			i += n
+		case css[i] == '\\':
+			i += 2
+		case hasPrefixFold(css[i:], "url(") && (i == 0 || !isCSSNameChar(css[i-1])):
+			u, n := readCSSURL(css[i+4:])
+			add(u)
+			i += 4 + n
+		case hasPrefixFold(css[i:], "@import"):
+			i += len("@import")
+			for i < len(css) && isCSSSpace(css[i]) {
+				i++
+			}
+			// @import url(...) is handled as regular url token
+			if i < len(css) && (css[i] == '"' || css[i] == '\'') {
+				u, n := readCSSString(css[i:])
+				add(u)
+				i += n
+			}
+		default:
+			i++
+		}
+	}
+	return
+}
+
+// readCSSString read quoted string, return its unescaped value
+// and length including quotes
+func readCSSString(s string) (string, int) {
+	q := s[0]
+	var b []byte
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case q:
+			return string(b), i + 1
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				if s[i] != '\n' {
+					b = append(b, s[i])
+				}
+			}
+		case '\n':
+			// unterminated string ends on new line
+			return string(b), i
+		default:
+			b = append(b, s[i])
+		}
+	}
+	return string(b), len(s)
+}
+
+// readCSSURL read url(...) content after the opening parenthesis,
+// return url and length including the closing parenthesis
+func readCSSURL(s string) (string, int) {
+	i := 0
+	for i < len(s) && isCSSSpace(s[i]) {
+		i++
+	}
+
+	var u string
+	if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+		v, n := readCSSString(s[i:])
+		u = v
+		i += n
+	} else {
+		var b []byte
+		for ; i < len(s) && s[i] != ')' && !isCSSSpace(s[i]); i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			b = append(b, s[i])
+		}
+		u = string(b)
+	}
+
+	if end := strings.IndexByte(s[i:], ')'); end >= 0 {
+		return u, i + end + 1
+	}
+	return u, len(s)
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func isCSSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isCSSNameChar(c byte) bool {
+	return c == '-' || c == '_' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// isCSS return true if content type is text/css
+func isCSS(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	return err == nil && t == "text/css"
+}
+
+// enqueCSSDependencies enqueue url(...) and @import targets of stylesheet,
+// they are resolved relative to the stylesheet url
+func (c *Crawler) enqueCSSDependencies(a *Asset, depth int) {
+	for _, ref := range ParseCSS(string(a.GetBody())) {
+		u, err := c.normalizeURL(resolveURL(a.GetPath(), ref), c.assetScope)
+		if err != nil {
+			if err != errAnotherDomain && err != errNotHTTP {
+				log.Printf("fail normilize url: %s error: %s", ref, err)
+			}
+			continue
+		}
+		c.enqueUploadAsset(u, a.GetPath(), depth)
+	}
+}
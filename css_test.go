@@ -0,0 +1,35 @@
+package crawler_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestParseCSS(t *testing.T) {
+	css := `
+@import "base.css";
+@import url('print.css') print;
+@IMPORT 'fonts.css';
+/* url(commented.png) @import "commented.css"; */
+body { background: URL( "img/bg.png" ) no-repeat; }
+.a { background-image: url(../img/a\(1\).png); }
+.b { content: "url(not-url.png)"; }
+.c { filter: url(#svg-filter); mask: url(data:image/png;base64,AAA=); }
+.d { myurl(x.png); }
+@font-face { src: url(font.woff2) format("woff2"), url('font.woff') format('woff'); }
+`
+	expected := []string{
+		"base.css",
+		"print.css",
+		"fonts.css",
+		"img/bg.png",
+		"../img/a(1).png",
+		"font.woff2",
+		"font.woff",
+	}
+	if got := crawler.ParseCSS(css); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, gotten %v", expected, got)
+	}
+}
@@ -0,0 +1,77 @@
+package crawler
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// NewStatusBuffer export status buffer for tests
+var NewStatusBuffer = newStatusBuffer
+
+// ItemStatus return status of item for tests
+func ItemStatus(i Item) Status {
+	return i.status
+}
+
+// NewHostScheduler export host scheduler for tests
+func NewHostScheduler(next http.RoundTripper, rps float64, conns int) *hostScheduler {
+	s := newHostScheduler(next)
+	s.configure(rps, conns)
+	return s
+}
+
+// RetryAfter export Retry-After parser for tests
+var RetryAfter = retryAfter
+
+// HostBackoff return delays of n subsequent backoffs of host
+// without Retry-After
+func HostBackoff(n int) []time.Duration {
+	var h hostSlot
+	res := make([]time.Duration, n)
+	for i := range res {
+		h.delay(0)
+		res[i] = h.backoff
+	}
+	return res
+}
+
+// Backoff export retry backoff for tests
+func Backoff(p RetryPolicy, n int) time.Duration {
+	return p.backoff(n)
+}
+
+// IsRetryable export retryable error check for tests
+func IsRetryable(p RetryPolicy, err error) bool {
+	return p.isRetryable(err)
+}
+
+// StatusError return unexpected status error for tests
+func StatusError(code int) error {
+	return statusError{code}
+}
+
+// URLStatus return status of url for tests
+func URLStatus(s *State, url string) Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, _ := s.get(url)
+	return i.status
+}
+
+// ScanCrawl export crawl row scanner for tests
+var ScanCrawl = scanCrawl
+
+// MigrationsCount is the latest schema version
+var MigrationsCount = len(migrations)
+
+// MigratePG apply migrations of host table up to version for tests
+func MigratePG(connection, host string, version int) error {
+	db, err := sql.Open("postgres", connection)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	pgs := &PGStorage{db: db, tableName: host}
+	return pgs.migrateTo(version)
+}
@@ -0,0 +1,81 @@
+package crawler_test
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestFetchStatuses(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/r1">r</a><a href="/final">f</a><a href="/missing">m</a></body></html>`)
+		case "/r1":
+			http.Redirect(w, r, "/r2", http.StatusMovedPermanently)
+		case "/r2":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/final":
+			fmt.Fprint(w, `<html><body>final</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer tc.close()
+	errs := tc.dir + "/errors/" + tc.srv.Listener.Addr().String()
+
+	tc.run(nil)
+	for url, code := range map[string]int{"/r1": 301, "/r2": 302} {
+		if s := crawler.URLStatus(tc.state, tc.url(url)); s != crawler.RedirectStatus {
+			t.Errorf("%s: expected redirect status, gotten %d", url, s)
+		}
+		if c := tc.state.HTTPStatus(tc.url(url)); c != code {
+			t.Errorf("%s: expected http status %d, gotten %d", url, code, c)
+		}
+	}
+	if u, ok := tc.state.LocalURL(tc.url("/r1")); u != tc.url("/final") || !ok {
+		t.Errorf("expected redirect chain to saved /final, gotten %s %t", u, ok)
+	}
+	if requests["/final"] != 1 {
+		t.Errorf("expected redirect target and link deduplicated, gotten %d requests", requests["/final"])
+	}
+
+	if s := crawler.URLStatus(tc.state, tc.url("/missing")); s != crawler.FailedStatus {
+		t.Errorf("expected failed status for 404, gotten %d", s)
+	}
+	if c := tc.state.HTTPStatus(tc.url("/missing")); c != http.StatusNotFound {
+		t.Errorf("expected 404 http status, gotten %d", c)
+	}
+	if _, err := os.Stat(tc.path("/missing/index.html.gz")); !os.IsNotExist(err) {
+		t.Error("expected error page is not saved to output")
+	}
+	if _, err := os.Stat(errs); !os.IsNotExist(err) {
+		t.Error("expected no errors output")
+	}
+
+	// error pages are saved to errors output
+	os.RemoveAll(tc.path(""))
+	tc.state = crawler.NewState(nil)
+	tc.run(func(c *crawler.Crawler) { c.ErrorsOutput = tc.dir + "/errors/" })
+	if _, err := os.Stat(errs + "/missing/index.html.gz"); err != nil {
+		t.Errorf("expected error page in errors output: %s", err)
+	}
+	if _, err := os.Stat(tc.path("/missing/index.html.gz")); !os.IsNotExist(err) {
+		t.Error("expected error page is not saved to output")
+	}
+	if _, err := os.Stat(tc.path("/final/index.html.gz")); err != nil {
+		t.Error(err)
+	}
+	if s := crawler.URLStatus(tc.state, tc.url("/missing")); s != crawler.FailedStatus {
+		t.Errorf("expected failed status for saved error page, gotten %d", s)
+	}
+}
@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+var _ Storage = new(FileStorage)
+
+// FileStorage write statuses to append-only file, one json record
+// with the same columns as postgres table per line. The last record
+// of url wins, file is compacted on load.
+type FileStorage struct {
+	path string
+	f    *os.File
+	mu   sync.Mutex
+}
+
+type fileRecord struct {
+	URL         string   `json:"url"`
+	Type        ItemType `json:"type"`
+	Status      Status   `json:"status"`
+	Depth       int      `json:"depth,omitempty"`
+	Attempts    int      `json:"attempts,omitempty"`
+	LastError   string   `json:"last_error,omitempty"`
+	HTTPStatus  int      `json:"http_status,omitempty"`
+	Redirect    string   `json:"redirect,omitempty"`
+	LastMod     string   `json:"lastmod,omitempty"`
+	ETag        string   `json:"etag,omitempty"`
+	Modified    string   `json:"modified,omitempty"`
+	Hash        string   `json:"hash,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	Size        int      `json:"size,omitempty"`
+	Parent      string   `json:"parent,omitempty"`
+}
+
+// NewFileStorage return new file storage instance, file is created
+// if not exists
+func NewFileStorage(path string) (*FileStorage, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStorage{path: path, f: f}, nil
+}
+
+// Close file
+func (fs *FileStorage) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.f.Close()
+}
+
+// Load state from file, incomplete last record of interrupted
+// write is skipped
+func (fs *FileStorage) Load() (*State, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	state := NewState(fs)
+
+	r, err := os.Open(fs.path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	items := make(map[string]Item)
+	var urls []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var rec fileRecord
+		if json.Unmarshal(sc.Bytes(), &rec) != nil || rec.URL == "" {
+			continue
+		}
+		if _, ok := items[rec.URL]; !ok {
+			urls = append(urls, rec.URL)
+		}
+		items[rec.URL] = rec.item()
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, url := range urls {
+		state.AddProgress(url, items[url])
+	}
+	state.SetEmpty(len(urls) == 0)
+	return state, fs.compact(urls, items)
+}
+
+// Clear truncate file and return empty state
+func (fs *FileStorage) Clear() (*State, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.f.Truncate(0); err != nil {
+		return nil, err
+	}
+	return NewState(fs), nil
+}
+
+// SetStatus append record for concret url
+func (fs *FileStorage) SetStatus(url string, i Item) error {
+	b, err := json.Marshal(newFileRecord(url, i))
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, err = fs.f.Write(append(b, '\n'))
+	return err
+}
+
+// compact rewrite file with the last records only
+func (fs *FileStorage) compact(urls []string, items map[string]Item) error {
+	tmp := fs.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, url := range urls {
+		if err = enc.Encode(newFileRecord(url, items[url])); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, fs.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	fs.f.Close()
+	fs.f, err = os.OpenFile(fs.path, os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+func newFileRecord(url string, i Item) fileRecord {
+	return fileRecord{
+		URL:         url,
+		Type:        i.itype,
+		Status:      i.status,
+		Depth:       i.depth,
+		Attempts:    i.attempts,
+		LastError:   i.lastErr,
+		HTTPStatus:  i.httpStatus,
+		Redirect:    i.redirect,
+		LastMod:     i.lastMod,
+		ETag:        i.etag,
+		Modified:    i.modified,
+		Hash:        i.hash,
+		ContentType: i.contentType,
+		Size:        i.size,
+		Parent:      i.parent,
+	}
+}
+
+func (r fileRecord) item() Item {
+	return Item{
+		itype:       r.Type,
+		status:      r.Status,
+		depth:       r.Depth,
+		attempts:    r.Attempts,
+		lastErr:     r.LastError,
+		httpStatus:  r.HTTPStatus,
+		redirect:    r.Redirect,
+		lastMod:     r.LastMod,
+		etag:        r.ETag,
+		modified:    r.Modified,
+		hash:        r.Hash,
+		contentType: r.ContentType,
+		size:        r.Size,
+		parent:      r.Parent,
+	}
+}
@@ -0,0 +1,116 @@
+package crawler_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestFileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.jsonl")
+
+	fs, err := crawler.NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := fs.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.MarkAsInFlight("http://a.com/", crawler.PageType, 0)
+	state.MarkAsInFlight("http://a.com/a", crawler.PageType, 1)
+	state.MarkAsInFlight("http://a.com/a.css", crawler.AssetType, 1)
+	state.MarkAsSaved("http://a.com/", crawler.PageType)
+	state.MarkAsDisallowed("http://a.com/private", crawler.PageType, 1)
+	state.Close()
+
+	// interrupted write leaves incomplete record
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"url":"http://a.com/a","ty`)
+	f.Close()
+
+	fs, err = crawler.NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	state, err = fs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.IsEmpty() || !state.IsSaved("http://a.com/") {
+		t.Error("expected saved url in loaded state")
+	}
+	inflight := state.GetInflight()
+	if len(inflight) != 2 {
+		t.Errorf("expected 2 in flight urls, gotten %v", inflight)
+	}
+	if _, ok := inflight["http://a.com/a.css"]; !ok {
+		t.Error("expected asset in flight")
+	}
+
+	// loaded state is compacted and writable
+	state.MarkAsSaved("http://a.com/a", crawler.PageType)
+	state, err = fs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.IsSaved("http://a.com/a") || len(state.GetInflight()) != 1 {
+		t.Error("expected status change after load")
+	}
+}
+
+func TestFileStorageCrawl(t *testing.T) {
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/a">a</a></body></html>`)
+		case "/a":
+			fmt.Fprint(w, `<html><body>a</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer tc.close()
+	path := filepath.Join(tc.dir, "state.jsonl")
+
+	fs, err := crawler.NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.state, err = fs.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	tc.run(nil)
+	tc.state.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := make(map[string]map[string]interface{})
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var rec map[string]interface{}
+		if json.Unmarshal([]byte(line), &rec) == nil {
+			recs[rec["url"].(string)] = rec
+		}
+	}
+	rec := recs[tc.url("/a")]
+	ct, _ := rec["content_type"].(string)
+	if rec["status"] != float64(crawler.SavedStatus) || rec["parent"] != tc.url("/") ||
+		!strings.HasPrefix(ct, "text/html") || rec["size"] == nil {
+		t.Errorf("expected saved url with parent, content type and size, gotten %v", rec)
+	}
+}
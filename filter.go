@@ -0,0 +1,224 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// URLFilter is ordered list of include and exclude rules.
+// The first matched rule decides, if no one matches url is allowed
+// only when there are no include rules.
+//
+// Rule format is [+-]kind:pattern, + includes and - excludes url:
+//
+//	+regex:^https?://example\.com/docs/  url regular expression
+//	-glob:/blog/tag/**                   path glob, * and ? do not match /
+//	+host:*.example.com                  host or host wildcard
+//	-ext:pdf,zip                         comma separated path extensions
+//	-mime:video/*                        comma separated content types
+//
+// Content type is guessed by extension before upload and checked
+// again by Content-Type header of the response.
+type URLFilter struct {
+	rules    []filterRule
+	includes int
+	hasMIME  bool
+}
+
+type filterRule struct {
+	include bool
+	match   func(u *url.URL, mimeType string) bool
+	mime    bool
+}
+
+// NewURLFilter return filter with rules
+func NewURLFilter(rules ...string) (*URLFilter, error) {
+	f := &URLFilter{}
+	for _, r := range rules {
+		if err := f.Add(r); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Add rule to the end of the list
+func (f *URLFilter) Add(rule string) error {
+	r, err := parseFilterRule(strings.TrimSpace(rule))
+	if err != nil {
+		return err
+	}
+	f.rules = append(f.rules, r)
+	if r.include {
+		f.includes++
+	}
+	if r.mime {
+		f.hasMIME = true
+	}
+	return nil
+}
+
+// Load rules from reader, one rule per line, # starts comment
+func (f *URLFilter) Load(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err := f.Add(line); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// Allow return true if url passes the filter, empty mimeType
+// is guessed by url extension
+func (f *URLFilter) Allow(u string, mimeType string) bool {
+	if f == nil || len(f.rules) == 0 {
+		return true
+	}
+	t, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(path.Ext(t.Path))
+	}
+	if mimeType != "" {
+		if mt, _, err := mime.ParseMediaType(mimeType); err == nil {
+			mimeType = mt
+		}
+	}
+
+	for _, r := range f.rules {
+		if r.match(t, mimeType) {
+			return r.include
+		}
+	}
+	return f.includes == 0
+}
+
+// HasMIMERules return true if response content type should be checked
+func (f *URLFilter) HasMIMERules() bool {
+	return f != nil && f.hasMIME
+}
+
+func parseFilterRule(s string) (filterRule, error) {
+	var r filterRule
+	if s == "" || (s[0] != '+' && s[0] != '-') {
+		return r, fmt.Errorf("filter rule %q must start with + or -", s)
+	}
+	r.include = s[0] == '+'
+
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return r, fmt.Errorf("filter rule %q must be in [+-]kind:pattern format", s)
+	}
+	kind, pattern := strings.ToLower(s[1:i]), s[i+1:]
+	if pattern == "" {
+		return r, fmt.Errorf("filter rule %q has empty pattern", s)
+	}
+
+	switch kind {
+	case "regex":
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return r, err
+		}
+		r.match = func(u *url.URL, _ string) bool {
+			return re.MatchString(u.String())
+		}
+	case "glob":
+		re, err := globToRegexp(pattern)
+		if err != nil {
+			return r, err
+		}
+		r.match = func(u *url.URL, _ string) bool {
+			return re.MatchString(u.Path)
+		}
+	case "host":
+		pattern = strings.ToLower(pattern)
+		r.match = func(u *url.URL, _ string) bool {
+			return matchHost(pattern, u.Hostname())
+		}
+	case "ext":
+		exts := make(map[string]bool)
+		for _, e := range strings.Split(pattern, ",") {
+			exts[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(e), "."))] = true
+		}
+		r.match = func(u *url.URL, _ string) bool {
+			return exts[strings.ToLower(strings.TrimPrefix(path.Ext(u.Path), "."))]
+		}
+	case "mime":
+		types := strings.Split(strings.ToLower(pattern), ",")
+		r.mime = true
+		r.match = func(_ *url.URL, mimeType string) bool {
+			return matchMIME(types, mimeType)
+		}
+	default:
+		return r, fmt.Errorf("filter rule %q has unknown kind %q", s, kind)
+	}
+	return r, nil
+}
+
+// globToRegexp convert path glob to regexp: ** match any chars,
+// * and ? match any chars except /
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b bytes.Buffer
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// matchHost match host by exact name or *.domain wildcard,
+// wildcard match the domain itself and all its subdomains
+func matchHost(pattern, host string) bool {
+	host = strings.ToLower(host)
+	if strings.HasPrefix(pattern, "*.") {
+		domain := pattern[2:]
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+	return host == pattern
+}
+
+// matchMIME match content type by patterns like text/html or image/*
+func matchMIME(patterns []string, mimeType string) bool {
+	if mimeType == "" {
+		return false
+	}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if strings.HasSuffix(p, "/*") {
+			if strings.HasPrefix(mimeType, p[:len(p)-1]) {
+				return true
+			}
+		} else if p == mimeType {
+			return true
+		}
+	}
+	return false
+}
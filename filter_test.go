@@ -0,0 +1,69 @@
+package crawler_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestURLFilter(t *testing.T) {
+	f, err := crawler.NewURLFilter(
+		"-glob:/docs/private/**",
+		"+glob:/docs/**",
+		"+host:*.cdn.example.com",
+		"+mime:image/*",
+	)
+	if err != nil {
+		t.Fatalf("create filter error: %s", err)
+	}
+	err = f.Load(strings.NewReader(`
+# archives are never needed
+-ext:zip,PDF
++regex:^https://example\.com/blog/[0-9]+$
+`))
+	if err != nil {
+		t.Fatalf("load rules error: %s", err)
+	}
+
+	cases := []struct {
+		url      string
+		mime     string
+		expected bool
+	}{
+		{"https://example.com/docs/", "", true},
+		{"https://example.com/docs/a/b.html", "", true},
+		{"https://example.com/docs/private/a.html", "", false},
+		{"https://example.com/docs/a.zip", "", true},
+		{"https://example.com/a.zip", "", false},
+		{"https://example.com/a.pdf", "", false},
+		{"https://static.cdn.example.com/app.js", "", true},
+		{"https://cdn.example.com/app.js", "", true},
+		{"https://notcdn.example.com/app.js", "", false},
+		{"https://example.com/img/a.png", "", true},
+		{"https://example.com/img/a", "image/png; charset=binary", true},
+		{"https://example.com/img/a", "", false},
+		{"https://example.com/blog/12", "", true},
+		{"https://example.com/blog/tag", "", false},
+		{"https://example.com/", "", false},
+	}
+	for _, c := range cases {
+		if got := f.Allow(c.url, c.mime); got != c.expected {
+			t.Errorf("allow %s (%s): expected %t, gotten %t", c.url, c.mime, c.expected, got)
+		}
+	}
+
+	f, _ = crawler.NewURLFilter("-glob:/blog/tag/*")
+	if !f.Allow("https://example.com/", "") {
+		t.Error("expected url allowed without include rules")
+	}
+	if f.Allow("https://example.com/blog/tag/go", "") {
+		t.Error("expected /blog/tag/go excluded")
+	}
+
+	for _, r := range []string{"glob:/a", "+unknown:a", "+regex:(", "+ext:", "-glob"} {
+		if _, err := crawler.NewURLFilter(r); err == nil {
+			t.Errorf("expected error for rule %q", r)
+		}
+	}
+}
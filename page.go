@@ -12,13 +12,15 @@ import (
 
 // Page is html single page structure
 type Page struct {
-	path   string
-	body   []byte
-	Assets []string
-	Pages  []string
+	path        string
+	body        []byte
+	contentType string
+	Assets      []string
+	Pages       []string
 }
 
 // NewPage parse http response, find assets links and pages links.
+// Links are resolved against the page url or <base href> if present.
 // Return page instance or error.
 // The response's body is closed on return.
 func NewPage(path string, res *http.Response) (*Page, error) {
@@ -33,12 +35,20 @@ func NewPage(path string, res *http.Response) (*Page, error) {
 		return nil, err
 	}
 	pgs, asts := parseDoc(doc)
+	base := documentBase(path, doc)
+	for i := range pgs {
+		pgs[i] = resolveURL(base, pgs[i])
+	}
+	for i := range asts {
+		asts[i] = resolveURL(base, asts[i])
+	}
 
 	return &Page{
-		path:   path,
-		body:   body,
-		Assets: asts,
-		Pages:  pgs,
+		path:        path,
+		body:        body,
+		contentType: res.Header.Get("Content-Type"),
+		Assets:      asts,
+		Pages:       pgs,
 	}, nil
 }
 
@@ -52,6 +62,11 @@ func (p *Page) GetPath() string {
 	return p.path
 }
 
+// GetContentType return response content type
+func (p *Page) GetContentType() string {
+	return p.contentType
+}
+
 // Free set body to nil
 func (p *Page) Free() {
 	p.body = nil
@@ -62,6 +77,38 @@ func (p *Page) GetType() ItemType {
 	return PageType
 }
 
+// assetAttrs is tags attributes which contain asset links,
+// srcset attributes contain comma separated candidates list
+var assetAttrs = map[string][]string{
+	"script": {"src"},
+	"img":    {"src", "srcset"},
+	"source": {"src", "srcset"},
+	"video":  {"src", "poster"},
+	"audio":  {"src"},
+	"track":  {"src"},
+	"object": {"data"},
+	"embed":  {"src"},
+}
+
+// assetRels is link tag rel values which point to assets
+var assetRels = map[string]bool{
+	"stylesheet":       true,
+	"icon":             true,
+	"apple-touch-icon": true,
+	"mask-icon":        true,
+	"manifest":         true,
+	"preload":          true,
+	"modulepreload":    true,
+}
+
+// documentBase return base url for links of the document
+func documentBase(path string, doc *goquery.Document) string {
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		return resolveURL(path, href)
+	}
+	return path
+}
+
 func parseDoc(doc *goquery.Document) (pages []string, assets []string) {
 	doc.Find("a").Each(func(i int, s *goquery.Selection) {
 		p, ok := s.Attr("href")
@@ -72,24 +119,57 @@ func parseDoc(doc *goquery.Document) (pages []string, assets []string) {
 		pages = append(pages, p)
 	})
 
-	tags := map[string]string{
-		"link":   "href",
-		"script": "src",
-	}
+	doc.Find("link").Each(func(i int, s *goquery.Selection) {
+		a, ok := s.Attr("href")
+		if !ok {
+			log.Printf("bad link tag item: %s", s.Text())
+			return
+		}
+		if isAssetLink(s.AttrOr("rel", "")) {
+			assets = append(assets, a)
+		}
+	})
 
-	for tag, attr := range tags {
+	for tag, attrs := range assetAttrs {
 		doc.Find(tag).Each(func(i int, s *goquery.Selection) {
-			a, ok := s.Attr(attr)
-			if !ok {
-				if tag != "script" {
-					log.Printf("bad %s tag item: %s", tag, s.Text())
+			for _, attr := range attrs {
+				a, ok := s.Attr(attr)
+				if !ok {
+					continue
+				}
+				if attr == "srcset" {
+					assets = append(assets, parseSrcset(a)...)
+				} else {
+					assets = append(assets, a)
 				}
-				return
-			}
-			if strings.Contains(a, ".css") || strings.Contains(a, ".js") {
-				assets = append(assets, a)
 			}
 		})
 	}
+
+	doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		assets = append(assets, ParseCSS(s.AttrOr("style", ""))...)
+	})
+	doc.Find("style").Each(func(i int, s *goquery.Selection) {
+		assets = append(assets, ParseCSS(s.Text())...)
+	})
+	return
+}
+
+func isAssetLink(rel string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if assetRels[r] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSrcset return urls of srcset candidates like "a.png 1x, b.png 2x"
+func parseSrcset(srcset string) (urls []string) {
+	for _, c := range strings.Split(srcset, ",") {
+		if f := strings.Fields(c); len(f) > 0 {
+			urls = append(urls, f[0])
+		}
+	}
 	return
 }
@@ -1,7 +1,11 @@
 package crawler_test
 
 import (
+	"io/ioutil"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/chapsuk/crawler"
@@ -19,3 +23,92 @@ func TestNewPage(t *testing.T) {
 		t.Errorf("create page error: %s", err)
 	}
 }
+
+func TestNewPageAssets(t *testing.T) {
+	body := `<html><head>
+<link rel="stylesheet" href="/s.css">
+<link rel="shortcut icon" href="/favicon.ico">
+<link rel="canonical" href="/canonical">
+<script src="/app"></script>
+</head><body style="background: url('/bg.png')">
+<a href="/page">page</a>
+<img src="/a.png" srcset="/a-1x.png 1x, /a-2x.png 2x">
+<picture><source srcset="/p.webp"></picture>
+<video src="/v.mp4" poster="/poster.jpg"><source src="/v.webm"></video>
+<object data="/o.swf"></object>
+<embed src="/e.swf">
+</body></html>`
+	res := &http.Response{
+		Header: http.Header{"Content-Type": {"text/html"}},
+		Body:   ioutil.NopCloser(strings.NewReader(body)),
+	}
+
+	p, err := crawler.NewPage("http://example.com/", res)
+	if err != nil {
+		t.Fatalf("create page error: %s", err)
+	}
+
+	var expected []string
+	for _, a := range []string{
+		"/a-1x.png", "/a-2x.png", "/a.png", "/app", "/bg.png", "/e.swf",
+		"/favicon.ico", "/o.swf", "/p.webp", "/poster.jpg", "/s.css",
+		"/v.mp4", "/v.webm",
+	} {
+		expected = append(expected, "http://example.com"+a)
+	}
+	assets := append([]string{}, p.Assets...)
+	sort.Strings(assets)
+	if !reflect.DeepEqual(assets, expected) {
+		t.Errorf("assets: expected %v, gotten %v", expected, assets)
+	}
+	if len(p.Pages) != 1 || p.Pages[0] != "http://example.com/page" {
+		t.Errorf("unexpected pages: %v", p.Pages)
+	}
+	if p.GetContentType() != "text/html" {
+		t.Errorf("unexpected content type: %s", p.GetContentType())
+	}
+}
+
+func TestNewPageResolveLinks(t *testing.T) {
+	cases := []struct {
+		name     string
+		url      string
+		head     string
+		link     string
+		expected string
+	}{
+		{"relative", "http://example.com/docs/guide/", "", "intro.html", "http://example.com/docs/guide/intro.html"},
+		{"relative file base", "http://example.com/docs/guide/index.html", "", "intro.html", "http://example.com/docs/guide/intro.html"},
+		{"parent", "http://example.com/docs/guide/", "", "../img/a.png", "http://example.com/docs/img/a.png"},
+		{"current", "http://example.com/docs/guide/", "", "./a/./b/../c.html", "http://example.com/docs/guide/a/c.html"},
+		{"above root", "http://example.com/docs/", "", "../../../a.html", "http://example.com/a.html"},
+		{"absolute path", "http://example.com/docs/guide/", "", "/a.html", "http://example.com/a.html"},
+		{"protocol relative", "https://example.com/docs/", "", "//cdn.example.com/a.js", "https://cdn.example.com/a.js"},
+		{"absolute", "http://example.com/docs/", "", "http://other.com/a.html", "http://other.com/a.html"},
+		{"query only", "http://example.com/docs/list?page=1", "", "?page=2", "http://example.com/docs/list?page=2"},
+		{"spaces", "http://example.com/docs/", "", "  a.html\n", "http://example.com/docs/a.html"},
+		{"base path", "http://example.com/docs/guide/", `<base href="/static/">`, "a.png", "http://example.com/static/a.png"},
+		{"relative base", "http://example.com/docs/guide/", `<base href="../v2/">`, "a.html", "http://example.com/docs/v2/a.html"},
+		{"absolute base", "http://example.com/docs/", `<base href="https://mirror.example.com/root/">`, "../a.html", "https://mirror.example.com/a.html"},
+		{"base without href", "http://example.com/docs/", `<base target="_blank">`, "a.html", "http://example.com/docs/a.html"},
+	}
+
+	for _, c := range cases {
+		body := "<html><head>" + c.head + "</head><body><a href=\"" + c.link + "\">l</a><img src=\"" + c.link + "\"></body></html>"
+		res := &http.Response{
+			Header: http.Header{},
+			Body:   ioutil.NopCloser(strings.NewReader(body)),
+		}
+		p, err := crawler.NewPage(c.url, res)
+		if err != nil {
+			t.Errorf("%s: create page error: %s", c.name, err)
+			continue
+		}
+		if len(p.Pages) != 1 || p.Pages[0] != c.expected {
+			t.Errorf("%s: page link expected %s, gotten %v", c.name, c.expected, p.Pages)
+		}
+		if len(p.Assets) != 1 || p.Assets[0] != c.expected {
+			t.Errorf("%s: asset link expected %s, gotten %v", c.name, c.expected, p.Assets)
+		}
+	}
+}
@@ -1,7 +1,14 @@
 package crawler
 
-var (
-	queryCreateTableTpl = `
+// stageTable is temp table for batch of statuses
+const stageTable = "crawler_stage"
+
+// migrations change schema of statuses table, version of migration is
+// its index plus one. Applied migrations must not be changed, schema
+// changes are added as new migrations.
+var migrations = []string{
+	// 1: initial table
+	`
 CREATE TABLE IF NOT EXISTS "%s" (
     url     TEXT      PRIMARY KEY,
     type    INT       NOT NULL,
@@ -9,18 +16,157 @@ CREATE TABLE IF NOT EXISTS "%s" (
     created TIMESTAMP NOT NULL DEFAULT statement_timestamp(),
     updated TIMESTAMP NOT NULL DEFAULT statement_timestamp()
 );
+`,
+	// 2: depth, retries, responses, incremental crawl and dedup columns
+	`
+ALTER TABLE "%s"
+    ADD COLUMN IF NOT EXISTS depth       INT  NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS attempts    INT  NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_error  TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS http_status INT  NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS redirect    TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS lastmod     TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS etag        TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS modified    TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS hash        TEXT NOT NULL DEFAULT '';
+`,
+	// 3: response content, parent url and crawl metadata
+	`
+ALTER TABLE "%s"
+    ADD COLUMN content_type TEXT   NOT NULL DEFAULT '',
+    ADD COLUMN size         BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN parent       TEXT   NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS crawl_meta (
+    name     TEXT      PRIMARY KEY,
+    created  TIMESTAMP NOT NULL DEFAULT statement_timestamp(),
+    started  TIMESTAMP NOT NULL DEFAULT statement_timestamp(),
+    finished TIMESTAMP
+);
+`,
+	// 4: crawls registry, statuses are keyed by crawl and existing
+	// statuses are moved to new crawl
+	`
+CREATE TABLE IF NOT EXISTS crawls (
+    id       BIGSERIAL PRIMARY KEY,
+    host     TEXT      NOT NULL,
+    seeds    JSONB     NOT NULL DEFAULT '[]',
+    options  JSONB     NOT NULL DEFAULT '{}',
+    stats    JSONB     NOT NULL DEFAULT '{}',
+    started  TIMESTAMP NOT NULL DEFAULT statement_timestamp(),
+    finished TIMESTAMP
+);
+INSERT INTO crawls (host, started, finished)
+    SELECT '%[1]s', MIN(created), MAX(updated) FROM "%[1]s" HAVING COUNT(*) > 0;
+ALTER TABLE "%[1]s" ADD COLUMN crawl_id BIGINT;
+UPDATE "%[1]s" SET crawl_id = (SELECT MAX(id) FROM crawls WHERE host = '%[1]s');
+ALTER TABLE "%[1]s"
+    ALTER COLUMN crawl_id SET NOT NULL,
+    ADD FOREIGN KEY (crawl_id) REFERENCES crawls (id) ON DELETE CASCADE;
+DO $$
+DECLARE
+    pkey TEXT;
+BEGIN
+    -- primary key name is truncated for long table names
+    SELECT conname INTO pkey FROM pg_constraint
+        WHERE conrelid = quote_ident('%[1]s')::regclass AND contype = 'p';
+    IF pkey IS NOT NULL THEN
+        EXECUTE format('ALTER TABLE %%I DROP CONSTRAINT %%I', '%[1]s', pkey);
+    END IF;
+END $$;
+ALTER TABLE "%[1]s" ADD PRIMARY KEY (crawl_id, url);
+DROP TABLE IF EXISTS crawl_meta;
+`,
+}
+
+var (
+	queryCreateMigrations = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    table_name TEXT      NOT NULL,
+    version    INT       NOT NULL,
+    applied    TIMESTAMP NOT NULL DEFAULT statement_timestamp(),
+    PRIMARY KEY (table_name, version)
+);
+`
+	queryLockMigrations = `
+LOCK TABLE schema_migrations IN EXCLUSIVE MODE;
+`
+	// queryResetMigrations forget migrations of dropped table
+	queryResetMigrations = `
+DELETE FROM schema_migrations WHERE table_name = $1 AND to_regclass(quote_ident($1::text)) IS NULL;
+`
+	queryGetVersion = `
+SELECT COALESCE(MAX(version), 0) FROM schema_migrations WHERE table_name = $1;
+`
+	queryAddMigration = `
+INSERT INTO schema_migrations (table_name, version) VALUES ($1, $2);
+`
+	queryCreateCrawl = `
+INSERT INTO crawls (host, seeds, options) VALUES ($1, $2, $3) RETURNING id;
+`
+	queryLastCrawl = `
+SELECT id FROM crawls WHERE host = $1 ORDER BY id DESC LIMIT 1;
+`
+	queryResumeCrawl = `
+UPDATE crawls SET finished = NULL WHERE id = $1 AND host = $2;
+`
+	queryCrawlStats = `
+SELECT status, COUNT(*), COALESCE(SUM(size), 0) FROM "%s" WHERE crawl_id = $1 GROUP BY status;
+`
+	queryFinishCrawl = `
+UPDATE crawls SET
+    stats = $2,
+    finished = CASE WHEN $3 THEN statement_timestamp() END
+WHERE id = $1;
+`
+	queryGetCrawls = `
+SELECT id, host, seeds, options, stats, started, finished FROM crawls ORDER BY id;
+`
+	queryGetCrawl = `
+SELECT id, host, seeds, options, stats, started, finished FROM crawls WHERE id = $1;
+`
+	queryDeleteCrawl = `
+DELETE FROM crawls WHERE id = $1;
 `
-	queryClearTable = `
-TRUNCATE TABLE "%s";
+	queryCreateStage = `
+CREATE TEMP TABLE ` + stageTable + ` (LIKE "%s" INCLUDING DEFAULTS) ON COMMIT DROP;
 `
-	querySetStatus = `
+	queryMergeStage = `
 INSERT INTO "%s" (
+    crawl_id,
     url,
     type,
-    status
-) VALUES ($1, $2, $3) ON CONFLICT(url) DO UPDATE SET status = $3;
+    status,
+    depth,
+    attempts,
+    last_error,
+    http_status,
+    redirect,
+    lastmod,
+    etag,
+    modified,
+    hash,
+    content_type,
+    size,
+    parent
+) SELECT crawl_id, url, type, status, depth, attempts, last_error, http_status, redirect, lastmod, etag, modified, hash,
+    content_type, size, parent
+FROM ` + stageTable + ` ON CONFLICT(crawl_id, url) DO UPDATE SET
+    status = EXCLUDED.status,
+    attempts = EXCLUDED.attempts,
+    last_error = EXCLUDED.last_error,
+    http_status = EXCLUDED.http_status,
+    redirect = EXCLUDED.redirect,
+    lastmod = EXCLUDED.lastmod,
+    etag = EXCLUDED.etag,
+    modified = EXCLUDED.modified,
+    hash = EXCLUDED.hash,
+    content_type = EXCLUDED.content_type,
+    size = EXCLUDED.size,
+    parent = EXCLUDED.parent,
+    updated = statement_timestamp();
 `
 	queryGetAll = `
-SELECT url, type, status FROM "%s";
+SELECT url, type, status, depth, attempts, last_error, http_status, redirect, lastmod, etag, modified, hash,
+    content_type, size, parent FROM "%s" WHERE crawl_id = $1;
 `
 )
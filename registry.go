@@ -0,0 +1,125 @@
+package crawler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var errCrawlNotFound = errors.New("crawl not found")
+
+// Crawl is registered crawl of host
+type Crawl struct {
+	ID    int64
+	Host  string
+	Seeds []string
+	// Options is flags of crawl run
+	Options map[string]string
+	// Stats is count of urls by status and downloaded bytes
+	Stats   map[string]int64
+	Started time.Time
+	// Finished is zero if crawl is running or interrupted
+	Finished time.Time
+}
+
+// statusNames is status keys of crawl stats
+var statusNames = map[Status]string{
+	InFlightStatus:   "in_flight",
+	IgnoreStatus:     "ignored",
+	SavedStatus:      "saved",
+	DisallowedStatus: "disallowed",
+	LimitedStatus:    "limited",
+	FailedStatus:     "failed",
+	RedirectStatus:   "redirect",
+	FilteredStatus:   "filtered",
+}
+
+// PGRegistry list and delete crawls stored in postgres
+type PGRegistry struct {
+	db *sql.DB
+}
+
+// NewPGRegistry return new postgres crawls registry instance
+func NewPGRegistry(connection string) (*PGRegistry, error) {
+	db, err := sql.Open("postgres", connection)
+	if err != nil {
+		return nil, err
+	}
+	return &PGRegistry{db: db}, nil
+}
+
+// Close db connections
+func (r *PGRegistry) Close() error {
+	return r.db.Close()
+}
+
+// Crawls return all crawls ordered by id
+func (r *PGRegistry) Crawls() ([]Crawl, error) {
+	rws, err := r.db.Query(queryGetCrawls)
+	if isUndefinedTable(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rws.Close()
+
+	var crawls []Crawl
+	for rws.Next() {
+		c, err := scanCrawl(rws)
+		if err != nil {
+			return nil, err
+		}
+		crawls = append(crawls, c)
+	}
+	return crawls, rws.Err()
+}
+
+// Crawl return crawl by id
+func (r *PGRegistry) Crawl(id int64) (Crawl, error) {
+	c, err := scanCrawl(r.db.QueryRow(queryGetCrawl, id))
+	if err == sql.ErrNoRows || isUndefinedTable(err) {
+		return c, errCrawlNotFound
+	}
+	return c, err
+}
+
+// DeleteCrawl remove crawl with its statuses
+func (r *PGRegistry) DeleteCrawl(id int64) error {
+	res, err := r.db.Exec(queryDeleteCrawl, id)
+	if isUndefinedTable(err) {
+		return errCrawlNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errCrawlNotFound
+	}
+	return nil
+}
+
+func scanCrawl(row interface {
+	Scan(dest ...interface{}) error
+}) (Crawl, error) {
+	var c Crawl
+	var seeds, options, stats []byte
+	var finished pq.NullTime
+	err := row.Scan(&c.ID, &c.Host, &seeds, &options, &stats, &c.Started, &finished)
+	if err != nil {
+		return c, err
+	}
+	c.Finished = finished.Time
+	for _, f := range []struct {
+		b []byte
+		v interface{}
+	}{{seeds, &c.Seeds}, {options, &c.Options}, {stats, &c.Stats}} {
+		if err = json.Unmarshal(f.b, f.v); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
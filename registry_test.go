@@ -0,0 +1,66 @@
+package crawler_test
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chapsuk/crawler"
+)
+
+// fakeRow scan values to destinations like sql row
+type fakeRow []interface{}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if len(dest) != len(r) {
+		return fmt.Errorf("expected %d destinations, gotten %d", len(r), len(dest))
+	}
+	for i, d := range dest {
+		if s, ok := d.(sql.Scanner); ok {
+			if err := s.Scan(r[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r[i]))
+	}
+	return nil
+}
+
+func TestScanCrawl(t *testing.T) {
+	started := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Hour)
+
+	c, err := crawler.ScanCrawl(fakeRow{int64(1), "a.com", []byte(`["http://a.com/"]`),
+		[]byte(`{"depth":"2"}`), []byte(`{"saved":3,"bytes":100}`), started, finished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := crawler.Crawl{
+		ID:       1,
+		Host:     "a.com",
+		Seeds:    []string{"http://a.com/"},
+		Options:  map[string]string{"depth": "2"},
+		Stats:    map[string]int64{"saved": 3, "bytes": 100},
+		Started:  started,
+		Finished: finished,
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected %+v, gotten %+v", expected, c)
+	}
+
+	c, err = crawler.ScanCrawl(fakeRow{int64(2), "a.com", []byte(`[]`), []byte(`{}`), []byte(`{}`), started, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Finished.IsZero() {
+		t.Errorf("expected running crawl is not finished, gotten %s", c.Finished)
+	}
+
+	_, err = crawler.ScanCrawl(fakeRow{int64(3), "a.com", []byte(`{`), []byte(`{}`), []byte(`{}`), started, nil})
+	if err == nil {
+		t.Error("expected invalid seeds error")
+	}
+}
@@ -0,0 +1,113 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy describe when and how often failed uploads are repeated
+type RetryPolicy struct {
+	// MaxAttempts is total attempts count including the first one
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Jitter is random fraction of backoff in [0, 1] range
+	Jitter float64
+	// RetryStatuses is http status codes which are retried
+	RetryStatuses []int
+	// Retryable report is network error retryable, nil means
+	// network and unexpected EOF errors are retryable
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy retry network errors and gateway failures 3 times
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   3,
+	BaseBackoff:   1 * time.Second,
+	MaxBackoff:    30 * time.Second,
+	Jitter:        0.2,
+	RetryStatuses: []int{408, 429, 500, 502, 503, 504},
+}
+
+// statusError is returned for unexpected http response status
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected status: %d", e.code)
+}
+
+// isRetryableStatus return true if response with code should be retried
+func (p RetryPolicy) isRetryableStatus(code int) bool {
+	for _, c := range p.RetryStatuses {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryable return true if upload failed with err should be retried
+func (p RetryPolicy) isRetryable(err error) bool {
+	if e, ok := err.(statusError); ok {
+		return p.isRetryableStatus(e.code)
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return err == io.ErrUnexpectedEOF || err == io.EOF
+}
+
+// backoff return delay before next attempt after n failed attempts
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.BaseBackoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// fail record failed attempt and retry url upload after backoff if err is
+// retryable and attempts are not exhausted, otherwise mark url as failed
+// for response status error or as ignored
+func (c *Crawler) fail(url string, t ItemType, err error) {
+	n := c.state.AddAttempt(url, err)
+	if n >= c.Retry.MaxAttempts || !c.Retry.isRetryable(err) {
+		log.Printf("upload %s failed after %d attempts: %s", url, n, err)
+		if e, ok := err.(statusError); ok {
+			c.state.SetResponse(url, e.code, "")
+			c.state.MarkAsFailed(url, t)
+		} else {
+			c.state.MarkAsIgnored(url, t)
+		}
+		return
+	}
+
+	ch := c.uploadPageCh
+	if t == AssetType {
+		ch = c.uploadAssetCh
+	}
+	go func() {
+		if sleepContext(c.ctx, c.Retry.backoff(n)) == nil {
+			c.push(ch, url)
+		}
+	}()
+}
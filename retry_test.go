@@ -0,0 +1,96 @@
+package crawler_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := crawler.RetryPolicy{BaseBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	expected := []time.Duration{1, 1, 2, 4, 5, 5}
+	for n, d := range expected {
+		if b := crawler.Backoff(p, n); b != d*time.Second {
+			t.Errorf("attempt %d: expected %ds, gotten %s", n, d, b)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if b := crawler.Backoff(p, 2); b < 2*time.Second || b > 3*time.Second {
+			t.Fatalf("expected backoff with jitter in [2s, 3s], gotten %s", b)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	p := crawler.DefaultRetryPolicy
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{crawler.StatusError(503), true},
+		{crawler.StatusError(404), false},
+		{&url.Error{Op: "Get", URL: "http://a.com/", Err: &net.OpError{Op: "dial", Err: errors.New("refused")}}, true},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("parse error"), false},
+	}
+	for _, c := range cases {
+		if r := crawler.IsRetryable(p, c.err); r != c.retryable {
+			t.Errorf("%v: expected retryable %t, gotten %t", c.err, c.retryable, r)
+		}
+	}
+
+	p.Retryable = func(err error) bool { return false }
+	if crawler.IsRetryable(p, io.ErrUnexpectedEOF) {
+		t.Error("expected custom retryable func is used")
+	}
+}
+
+func TestRetryCrawl(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, `<html><body><a href="/flaky">a</a><a href="/down">b</a></body></html>`)
+		case r.URL.Path == "/flaky" && n > 2:
+			fmt.Fprint(w, `<html><body>flaky</body></html>`)
+		case r.URL.Path == "/flaky" || r.URL.Path == "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer tc.close()
+
+	c := tc.run(func(c *crawler.Crawler) {
+		c.Retry.BaseBackoff = 10 * time.Millisecond
+		c.Retry.Jitter = 0
+	})
+
+	if requests["/flaky"] != 3 || !tc.state.IsSaved(tc.url("/flaky")) {
+		t.Errorf("expected /flaky saved after 3 attempts, gotten %d attempts", requests["/flaky"])
+	}
+	if requests["/down"] != c.Retry.MaxAttempts {
+		t.Errorf("expected %d attempts of /down, gotten %d", c.Retry.MaxAttempts, requests["/down"])
+	}
+	if s := crawler.URLStatus(tc.state, tc.url("/down")); s != crawler.FailedStatus {
+		t.Errorf("expected exhausted url failed, gotten status %d", s)
+	}
+	if code := tc.state.HTTPStatus(tc.url("/down")); code != http.StatusBadGateway {
+		t.Errorf("expected 502 http status of exhausted url, gotten %d", code)
+	}
+}
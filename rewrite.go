@@ -0,0 +1,145 @@
+package crawler
+
+import (
+	"bytes"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+var styleURLRe = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+
+// isLinkAttr return true if tag attribute contain link to page or asset
+func isLinkAttr(tag, attr string) bool {
+	if attr == "href" {
+		return tag == "a" || tag == "link"
+	}
+	for _, a := range assetAttrs[tag] {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteLinks return page body with links to uploaded urls replaced by
+// paths relative to the page output file, so the result tree can be
+// browsed offline. Links point to .gz files of gzipped directory output.
+// Links to not uploaded in-scope urls are made absolute, off-site links
+// are left untouched. Only url() references of styles are rewritten,
+// @import with plain string is left as is.
+func (c *Crawler) rewriteLinks(p *Page) ([]byte, error) {
+	name, err := c.getOutputFileNameByURL(p.GetPath())
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := html.Parse(bytes.NewReader(p.GetBody()))
+	if err != nil {
+		return nil, err
+	}
+	d := goquery.NewDocumentFromNode(doc)
+	base := documentBase(p.GetPath(), d)
+	// links are made relative to the file, base would break them
+	d.Find("base[href]").RemoveAttr("href")
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode && n.Parent != nil && n.Parent.Data == "style" {
+			n.Data = c.rewriteStyle(name, base, n.Data)
+		}
+		if n.Type == html.ElementNode {
+			for i, a := range n.Attr {
+				switch {
+				case a.Key == "style":
+					n.Attr[i].Val = c.rewriteStyle(name, base, a.Val)
+				case !isLinkAttr(n.Data, a.Key):
+				case a.Key == "srcset":
+					n.Attr[i].Val = c.rewriteSrcset(name, base, a.Val)
+				default:
+					if l, ok := c.localLink(name, base, a.Val); ok {
+						n.Attr[i].Val = l
+					}
+				}
+			}
+		}
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			walk(ch)
+		}
+	}
+	walk(doc)
+
+	var b bytes.Buffer
+	if err := html.Render(&b, doc); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// rewriteSrcset rewrite each srcset candidate url
+func (c *Crawler) rewriteSrcset(from, base, srcset string) string {
+	cands := strings.Split(srcset, ",")
+	for i, cand := range cands {
+		f := strings.Fields(cand)
+		if len(f) == 0 {
+			continue
+		}
+		if l, ok := c.localLink(from, base, f[0]); ok {
+			f[0] = l
+		}
+		cands[i] = strings.Join(f, " ")
+	}
+	return strings.Join(cands, ", ")
+}
+
+// rewriteStyle rewrite url(...) values of inline style or style element
+func (c *Crawler) rewriteStyle(from, base, style string) string {
+	return styleURLRe.ReplaceAllStringFunc(style, func(m string) string {
+		sub := styleURLRe.FindStringSubmatch(m)
+		if l, ok := c.localLink(from, base, sub[1]); ok {
+			return "url(" + l + ")"
+		}
+		return m
+	})
+}
+
+// localLink return link resolved against base url and made relative
+// to from file, links to not uploaded urls are made absolute
+func (c *Crawler) localLink(from, base, link string) (string, bool) {
+	var frag string
+	if i := strings.Index(link, "#"); i >= 0 {
+		link, frag = link[:i], link[i:]
+	}
+	if strings.TrimSpace(link) == "" {
+		return "", false
+	}
+
+	abs := resolveURL(base, link)
+	u, err := c.normalizeURL(abs, c.assetScope)
+	if err != nil {
+		return "", false
+	}
+
+	u, ok := c.state.LocalURL(u)
+	if !ok {
+		return abs + frag, true
+	}
+
+	to, err := c.getOutputFileNameByURL(u)
+	if err != nil {
+		return "", false
+	}
+	if s, ok := c.Sink.(*DirSink); ok && s.gzip {
+		to += ".gz"
+	}
+	rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash(from)), filepath.FromSlash(to))
+	if err != nil {
+		return "", false
+	}
+	// file names may contain encoded query with % and @ chars
+	return (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath() + frag, true
+}
@@ -0,0 +1,128 @@
+package crawler_test
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestConvertLinks(t *testing.T) {
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body>
+<a href="/a#top">a</a>
+<a href="http://other.example/x">other</a>
+<img src="/img.png" srcset="/img.png 1x, /img2.png 2x">
+<div style="background: url('/bg.png')"></div>
+<style>body { background: url("/bg2.png") }</style>
+</body></html>`)
+		case "/a":
+			fmt.Fprint(w, `<html><body><a href="/">home</a><a href="/deep">deep</a></body></html>`)
+		case "/img.png", "/img2.png", "/bg.png", "/bg2.png":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "png")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer tc.close()
+
+	tc.run(func(c *crawler.Crawler) {
+		c.ConvertLinks = true
+		c.EnableGzip = false
+		c.MaxDepth = 1
+	})
+
+	page := tc.read("/index.html")
+	for _, expected := range []string{
+		`href="a/index.html#top"`,
+		`href="http://other.example/x"`,
+		`src="img.png"`,
+		`srcset="img.png 1x, img2.png 2x"`,
+		`url(bg.png)`,
+		`background: url(bg2.png)`,
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected %s in converted page:\n%s", expected, page)
+		}
+	}
+
+	page = tc.read("/a/index.html")
+	if !strings.Contains(page, `href="../index.html"`) {
+		t.Errorf("expected link to parent directory, gotten %s", page)
+	}
+	if !strings.Contains(page, `href="`+tc.url("/deep")+`"`) {
+		t.Errorf("expected absolute link to not uploaded url, gotten %s", page)
+	}
+	for _, name := range []string{"img.png", "img2.png", "bg.png", "bg2.png"} {
+		if _, err := os.Stat(tc.path("/" + name)); err != nil {
+			t.Errorf("expected linked asset: %s", err)
+		}
+	}
+}
+
+func TestConvertLinksBase(t *testing.T) {
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><head><base href="/sub/"></head><body><a href="x">x</a></body></html>`)
+		case "/sub/x":
+			fmt.Fprint(w, `<html><body>x</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer tc.close()
+
+	tc.run(func(c *crawler.Crawler) {
+		c.ConvertLinks = true
+		c.EnableGzip = false
+	})
+
+	page := tc.read("/index.html")
+	if !strings.Contains(page, `href="sub/x/index.html"`) || strings.Contains(page, `base href`) {
+		t.Errorf("expected link resolved against base and base href removed, gotten %s", page)
+	}
+}
+
+func TestConvertLinksGzip(t *testing.T) {
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><img src="/img.png"></body></html>`)
+		case "/a":
+			fmt.Fprint(w, `<html><body><a href="/">home</a></body></html>`)
+		case "/img.png":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "png")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer tc.close()
+
+	tc.run(func(c *crawler.Crawler) {
+		c.ConvertLinks = true
+		c.EnableGzip = true
+	})
+
+	for name, expected := range map[string][]string{
+		"/index.html.gz":   {`href="a/index.html.gz"`, `src="img.png.gz"`},
+		"/a/index.html.gz": {`href="../index.html.gz"`},
+	} {
+		page := tc.read(name)
+		for _, e := range expected {
+			if !strings.Contains(page, e) {
+				t.Errorf("expected %s in %s, gotten %s", e, name, page)
+			}
+		}
+	}
+	if _, err := os.Stat(tc.path("/img.png.gz")); err != nil {
+		t.Errorf("expected linked asset: %s", err)
+	}
+}
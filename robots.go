@@ -0,0 +1,265 @@
+package crawler
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultUserAgent sent with every request and used for robots.txt matching
+	DefaultUserAgent = "crawler"
+
+	robotsMaxSize = 512 * 1024
+)
+
+// Robots is parsed robots.txt rules group for single user-agent
+type Robots struct {
+	rules      []robotsRule
+	CrawlDelay time.Duration
+	Sitemaps   []string
+}
+
+type robotsRule struct {
+	allow bool
+	path  string
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsEntry is per host robots.txt cache item
+type robotsEntry struct {
+	once   sync.Once
+	robots *Robots
+}
+
+// ParseRobots parse robots.txt content and return rules group
+// which matches the agent or "*" group if no one matches.
+func ParseRobots(r io.Reader, agent string) *Robots {
+	var (
+		groups   []*robotsGroup
+		cur      *robotsGroup
+		sitemaps []string
+		inAgents bool
+	)
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		val := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				cur = &robotsGroup{}
+				groups = append(groups, cur)
+				inAgents = true
+			}
+			cur.agents = append(cur.agents, strings.ToLower(val))
+		case "allow", "disallow":
+			inAgents = false
+			if cur == nil {
+				continue
+			}
+			// empty disallow means allow all
+			if val == "" {
+				continue
+			}
+			cur.rules = append(cur.rules, robotsRule{allow: key == "allow", path: val})
+		case "crawl-delay":
+			inAgents = false
+			if cur == nil {
+				continue
+			}
+			if d, err := strconv.ParseFloat(val, 64); err == nil && d > 0 {
+				cur.crawlDelay = time.Duration(d * float64(time.Second))
+			}
+		case "sitemap":
+			sitemaps = append(sitemaps, val)
+		default:
+			inAgents = false
+		}
+	}
+
+	res := &Robots{Sitemaps: sitemaps}
+	if g := matchRobotsGroup(groups, agent); g != nil {
+		res.rules = g.rules
+		res.CrawlDelay = g.crawlDelay
+	}
+	return res
+}
+
+func matchRobotsGroup(groups []*robotsGroup, agent string) *robotsGroup {
+	agent = strings.ToLower(agent)
+	// product token without version, "crawler/1.0" -> "crawler"
+	if i := strings.IndexAny(agent, "/ "); i >= 0 {
+		agent = agent[:i]
+	}
+
+	var def *robotsGroup
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if a == "*" {
+				if def == nil {
+					def = g
+				}
+				continue
+			}
+			if agent != "" && strings.Contains(agent, a) {
+				return g
+			}
+		}
+	}
+	return def
+}
+
+// Allowed return true if path (with query) can be fetched.
+// The longest matched rule wins, allow wins on equal length.
+func (r *Robots) Allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	allow, length := true, -1
+	for _, rule := range r.rules {
+		if !matchRobotsPath(rule.path, path) {
+			continue
+		}
+		if len(rule.path) > length || (len(rule.path) == length && rule.allow) {
+			allow, length = rule.allow, len(rule.path)
+		}
+	}
+	return allow
+}
+
+// matchRobotsPath match path by pattern with * and $ wildcards
+func matchRobotsPath(pattern, path string) bool {
+	if strings.HasSuffix(pattern, "$") {
+		pattern = pattern[:len(pattern)-1]
+	} else {
+		pattern += "*"
+	}
+
+	// classic wildcard matching with single backtrack point
+	p, s, star, mark := 0, 0, -1, 0
+	for s < len(path) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, s
+			p++
+		case p < len(pattern) && pattern[p] == path[s]:
+			p++
+			s++
+		case star >= 0:
+			p = star + 1
+			mark++
+			s = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// isAllowedByRobots return true if url allowed by host robots.txt
+func (c *Crawler) isAllowedByRobots(u string) bool {
+	if !c.RespectRobots {
+		return true
+	}
+	t, err := url.Parse(u)
+	if err != nil {
+		return true
+	}
+	return c.getRobots(t).Allowed(t.RequestURI())
+}
+
+// getRobots return cached robots for url host, fetch it on first call
+func (c *Crawler) getRobots(u *url.URL) *Robots {
+	key := u.Scheme + "://" + u.Host
+
+	c.robotsMu.Lock()
+	e, ok := c.robots[key]
+	if !ok {
+		e = &robotsEntry{}
+		c.robots[key] = e
+	}
+	c.robotsMu.Unlock()
+
+	e.once.Do(func() {
+		e.robots = c.fetchRobots(u.Host, key+"/robots.txt")
+	})
+	return e.robots
+}
+
+// fetchRobots download and parse robots.txt of host, return nil
+// (allow all) if robots.txt is not available. Crawl-delay is applied
+// to the host even if robots.txt is redirected to another one.
+func (c *Crawler) fetchRobots(host, u string) *Robots {
+	var (
+		req *http.Request
+		res *http.Response
+		err error
+	)
+	for i := 0; i <= maxRedirects; i++ {
+		req, err = c.craeteRequest(u)
+		if err != nil {
+			log.Printf("create robots request error: %s", err)
+			return nil
+		}
+		res, err = c.httpClient.Do(req)
+		if err != nil {
+			log.Printf("http get: %s, error: %s", u, err)
+			return nil
+		}
+		if !isRedirectStatus(res.StatusCode) {
+			break
+		}
+		res.Body.Close()
+		loc, err := res.Location()
+		if err != nil {
+			return nil
+		}
+		u = loc.String()
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		r := ParseRobots(io.LimitReader(res.Body, robotsMaxSize), c.UserAgent)
+		if r.CrawlDelay > 0 {
+			c.scheduler.SetCrawlDelay(host, r.CrawlDelay)
+		}
+		return r
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		// access to robots.txt is restricted, treat as full disallow
+		return &Robots{rules: []robotsRule{{allow: false, path: "/"}}}
+	default:
+		ioutil.ReadAll(res.Body)
+		return nil
+	}
+}
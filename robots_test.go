@@ -0,0 +1,67 @@
+package crawler_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chapsuk/crawler"
+)
+
+const robotsTxt = `
+# comment
+User-agent: other
+Disallow: /
+
+User-agent: crawler
+User-agent: bot
+Disallow: /private/
+Allow: /private/public
+Disallow: /*.php$
+Disallow: /search?
+Crawl-delay: 1.5
+
+User-agent: *
+Disallow: /tmp/
+
+Sitemap: http://example.com/sitemap.xml
+`
+
+func TestParseRobots(t *testing.T) {
+	r := crawler.ParseRobots(strings.NewReader(robotsTxt), "crawler/1.0")
+
+	cases := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/", true},
+		{"/tmp/a", true},
+		{"/private/", false},
+		{"/private/a.html", false},
+		{"/private/public/a.html", true},
+		{"/index.php", false},
+		{"/index.php?a=1", true},
+		{"/search?q=1", false},
+		{"/search", true},
+	}
+	for _, c := range cases {
+		if got := r.Allowed(c.path); got != c.allowed {
+			t.Errorf("allowed %s: expected %t, gotten %t", c.path, c.allowed, got)
+		}
+	}
+
+	if r.CrawlDelay != 1500*time.Millisecond {
+		t.Errorf("crawl delay: expected 1.5s, gotten %s", r.CrawlDelay)
+	}
+	if len(r.Sitemaps) != 1 || r.Sitemaps[0] != "http://example.com/sitemap.xml" {
+		t.Errorf("unexpected sitemaps: %v", r.Sitemaps)
+	}
+
+	r = crawler.ParseRobots(strings.NewReader(robotsTxt), "unknown")
+	if r.Allowed("/tmp/a") {
+		t.Error("expected /tmp/a disallowed for * group")
+	}
+	if !r.Allowed("/private/") {
+		t.Error("expected /private/ allowed for * group")
+	}
+}
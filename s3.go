@@ -0,0 +1,369 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultS3PartSize is size of multipart upload part,
+	// bodies up to part size are uploaded with single request
+	DefaultS3PartSize = 8 * 1024 * 1024
+	// DefaultS3Concurrency is max count of concurrent requests to bucket
+	DefaultS3Concurrency = 16
+
+	// s3MinPartSize is min size of multipart upload part except the last one
+	s3MinPartSize = 5 * 1024 * 1024
+)
+
+var errS3Bucket = errors.New("empty s3 bucket")
+
+// S3Config is S3-compatible bucket settings
+type S3Config struct {
+	// Endpoint is scheme and host of storage, https://s3.amazonaws.com
+	// if empty
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// PathStyle address bucket as endpoint/bucket instead of
+	// bucket.endpoint, it is required by most of self-hosted storages
+	PathStyle bool
+	// Gzip upload gzipped bodies with Content-Encoding: gzip
+	Gzip bool
+	// PartSize is at least 5 MiB, smaller parts are rejected by S3
+	PartSize    int64
+	Concurrency int
+	// Retry is backoff of network errors and 5xx responses,
+	// DefaultRetryPolicy is used if MaxAttempts is zero
+	Retry RetryPolicy
+}
+
+// S3Sink upload files to S3-compatible bucket, object key is file
+// name with prefix. Requests are signed with AWS Signature Version 4.
+// Duplicates are uploaded as is.
+type S3Sink struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	sem      chan struct{}
+}
+
+// NewS3Sink return new S3 sink instance
+func NewS3Sink(cfg S3Config) (*S3Sink, error) {
+	if cfg.Bucket == "" {
+		return nil, errS3Bucket
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3.amazonaws.com"
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = DefaultS3PartSize
+	}
+	if cfg.PartSize < s3MinPartSize {
+		cfg.PartSize = s3MinPartSize
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultS3Concurrency
+	}
+	if cfg.Retry.MaxAttempts <= 0 {
+		cfg.Retry = DefaultRetryPolicy
+	}
+	e, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Sink{
+		cfg:      cfg,
+		endpoint: e,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		sem:      make(chan struct{}, cfg.Concurrency),
+	}, nil
+}
+
+// Write upload file, bodies larger than part size are uploaded by parts
+func (s *S3Sink) Write(f File, m Meta) error {
+	body := f.GetBody()
+	h := http.Header{}
+	ct := m.ContentType
+	if ct == "" {
+		ct = mime.TypeByExtension(path.Ext(m.Name))
+	}
+	if ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	if s.cfg.Gzip {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		w.Write(body)
+		w.Close()
+		body = b.Bytes()
+		h.Set("Content-Encoding", "gzip")
+	}
+
+	key := strings.TrimPrefix(path.Join(s.cfg.Prefix, m.Name), "/")
+	if int64(len(body)) <= s.cfg.PartSize {
+		_, err := s.do("PUT", key, nil, h, body)
+		return err
+	}
+	return s.multipartUpload(key, h, body)
+}
+
+// Close do nothing, uploads are finished by Write
+func (s *S3Sink) Close() error {
+	return nil
+}
+
+type s3InitiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompletePart struct {
+	PartNumber int
+	ETag       string
+}
+
+type s3Complete struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletePart `xml:"Part"`
+}
+
+func (s *S3Sink) multipartUpload(key string, h http.Header, body []byte) error {
+	res, err := s.do("POST", key, url.Values{"uploads": {""}}, h, nil)
+	if err != nil {
+		return err
+	}
+	var init s3InitiateResult
+	if err = xml.Unmarshal(res, &init); err != nil {
+		return err
+	}
+	if init.UploadID == "" {
+		return errors.New("s3 multipart upload without upload id")
+	}
+	upload := url.Values{"uploadId": {init.UploadID}}
+
+	n := (int64(len(body)) + s.cfg.PartSize - 1) / s.cfg.PartSize
+	parts := make([]s3CompletePart, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range parts {
+		start := int64(i) * s.cfg.PartSize
+		end := start + s.cfg.PartSize
+		if end > int64(len(body)) {
+			end = int64(len(body))
+		}
+		wg.Add(1)
+		go func(i int, part []byte) {
+			defer wg.Done()
+			q := url.Values{
+				"partNumber": {strconv.Itoa(i + 1)},
+				"uploadId":   {init.UploadID},
+			}
+			etag, err := s.putPart(key, q, part)
+			parts[i] = s3CompletePart{PartNumber: i + 1, ETag: etag}
+			errs[i] = err
+		}(i, body[start:end])
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			s.do("DELETE", key, upload, nil, nil)
+			return err
+		}
+	}
+	complete, err := xml.Marshal(s3Complete{Parts: parts})
+	if err != nil {
+		return err
+	}
+	_, err = s.do("POST", key, upload, nil, complete)
+	return err
+}
+
+func (s *S3Sink) putPart(key string, q url.Values, part []byte) (string, error) {
+	var etag string
+	_, err := s.request("PUT", key, q, nil, part, func(res *http.Response) {
+		etag = res.Header.Get("ETag")
+	})
+	return etag, err
+}
+
+// do send signed request and return response body
+func (s *S3Sink) do(method, key string, q url.Values, h http.Header, body []byte) ([]byte, error) {
+	return s.request(method, key, q, h, body, nil)
+}
+
+// request send signed request with retries of network errors and 5xx
+// responses after backoff, onSuccess is called with successful response
+func (s *S3Sink) request(method, key string, q url.Values, h http.Header, body []byte,
+	onSuccess func(*http.Response)) ([]byte, error) {
+	s.sem <- struct{}{}
+	defer func() { <-s.sem }()
+
+	var err error
+	for i := 0; i < s.cfg.Retry.MaxAttempts; i++ {
+		if i > 0 {
+			time.Sleep(s.cfg.Retry.backoff(i))
+		}
+		var req *http.Request
+		req, err = s.newRequest(method, key, q, h, body)
+		if err != nil {
+			return nil, err
+		}
+		var res *http.Response
+		res, err = s.client.Do(req)
+		if err != nil {
+			continue
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if isSuccessStatus(res.StatusCode) {
+			if onSuccess != nil {
+				onSuccess(res)
+			}
+			return b, nil
+		}
+		err = fmt.Errorf("s3 %s %s: %s %s", method, key, res.Status, bytes.TrimSpace(b))
+		if res.StatusCode < 500 {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (s *S3Sink) newRequest(method, key string, q url.Values, h http.Header, body []byte) (*http.Request, error) {
+	u := *s.endpoint
+	if s.cfg.PathStyle {
+		u.Path = "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(q)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	s.sign(req, body, time.Now().UTC())
+	return req, nil
+}
+
+// sign add AWS Signature Version 4 authorization header
+func (s *S3Sink) sign(req *http.Request, body []byte, now time.Time) {
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	for k := range req.Header {
+		if k := strings.ToLower(k); k == "content-type" || k == "content-encoding" {
+			signed = append(signed, k)
+		}
+	}
+	sort.Strings(signed)
+
+	var headers bytes.Buffer
+	for _, k := range signed {
+		v := req.Header.Get(k)
+		if k == "host" {
+			v = req.URL.Host
+		}
+		headers.WriteString(k + ":" + strings.TrimSpace(v) + "\n")
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	crh := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crh[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, strings.Join(signed, ";"), sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath escape path by RFC 3986 keeping slashes
+func s3EscapePath(p string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(p); i++ {
+		ch := p[i]
+		if ch == '/' || isS3Unreserved(ch) {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery return query sorted by keys and escaped by RFC 3986
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Escape(s string) string {
+	return strings.Replace(s3EscapePath(s), "/", "%2F", -1)
+}
+
+func isS3Unreserved(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' ||
+		ch == '-' || ch == '_' || ch == '.' || ch == '~'
+}
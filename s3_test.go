@@ -0,0 +1,172 @@
+package crawler_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chapsuk/crawler"
+)
+
+// fakeS3 is in-memory bucket with multipart uploads
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+	parts   map[string][]byte
+	// fail is count of requests failed with 503
+	fail int
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") ||
+		r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail > 0 {
+		s.fail--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	key := r.URL.Path
+	switch {
+	case r.Method == "POST" && q.Get("uploadId") == "":
+		s.headers[key] = r.Header
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>up1</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == "PUT" && q.Get("partNumber") != "":
+		s.parts[q.Get("partNumber")] = body
+		w.Header().Set("ETag", `"`+q.Get("partNumber")+`"`)
+	case r.Method == "POST":
+		var b bytes.Buffer
+		for i := 1; i <= len(s.parts); i++ {
+			n := strconv.Itoa(i)
+			if !bytes.Contains(body, []byte(`<ETag>&#34;`+n+`&#34;</ETag>`)) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			b.Write(s.parts[n])
+		}
+		s.objects[key] = b.Bytes()
+	case r.Method == "PUT":
+		s.objects[key] = body
+		s.headers[key] = r.Header
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestS3Sink(t *testing.T) {
+	fake := &fakeS3{
+		objects: make(map[string][]byte),
+		headers: make(map[string]http.Header),
+		parts:   make(map[string][]byte),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	s, err := crawler.NewS3Sink(crawler.S3Config{
+		Endpoint:    srv.URL,
+		Bucket:      "bucket",
+		Prefix:      "mirror",
+		AccessKey:   "key",
+		SecretKey:   "secret",
+		PathStyle:   true,
+		Gzip:        true,
+		PartSize:    8,
+		Concurrency: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Write(newTestAsset(t, "http://a.com/a.css", "a{}"), crawler.Meta{Name: "a.com/a@v=1.css"})
+	if err != nil {
+		t.Fatalf("put object error: %s", err)
+	}
+	// random body is not compressed below min part size
+	b := make([]byte, 6*1024*1024)
+	rand.Read(b)
+	large := string(b)
+	err = s.Write(newTestAsset(t, "http://a.com/b.js", large), crawler.Meta{Name: "a.com/b.js"})
+	if err != nil {
+		t.Fatalf("multipart upload error: %s", err)
+	}
+
+	h := fake.headers["/bucket/mirror/a.com/a@v=1.css"]
+	if h == nil || h.Get("Content-Type") != "text/css; charset=utf-8" || h.Get("Content-Encoding") != "gzip" {
+		t.Errorf("unexpected object headers %v", h)
+	}
+	for key, expected := range map[string]string{
+		"/bucket/mirror/a.com/a@v=1.css": "a{}",
+		"/bucket/mirror/a.com/b.js":      large,
+	} {
+		gz, err := gzip.NewReader(bytes.NewReader(fake.objects[key]))
+		if err != nil {
+			t.Fatalf("object %s error: %s", key, err)
+		}
+		b, _ := ioutil.ReadAll(gz)
+		if string(b) != expected {
+			t.Errorf("object %s: expected %d bytes, gotten %d", key, len(expected), len(b))
+		}
+	}
+	// part size is raised to 5 MiB
+	if len(fake.parts) != 2 {
+		t.Errorf("expected multipart upload with 2 parts, gotten %d parts", len(fake.parts))
+	}
+}
+
+func TestS3SinkRetry(t *testing.T) {
+	fake := &fakeS3{
+		objects: make(map[string][]byte),
+		headers: make(map[string]http.Header),
+		parts:   make(map[string][]byte),
+		fail:    2,
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	s, err := crawler.NewS3Sink(crawler.S3Config{
+		Endpoint:  srv.URL,
+		Bucket:    "bucket",
+		AccessKey: "key",
+		SecretKey: "secret",
+		PathStyle: true,
+		Retry:     crawler.RetryPolicy{MaxAttempts: 3, BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = s.Write(newTestAsset(t, "http://a.com/a.css", "a{}"), crawler.Meta{Name: "a.com/a.css"})
+	if err != nil {
+		t.Fatalf("expected upload after retries, gotten %s", err)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("expected retries after backoff, uploaded in %s", d)
+	}
+
+	fake.mu.Lock()
+	fake.fail = 3
+	fake.mu.Unlock()
+	if err = s.Write(newTestAsset(t, "http://a.com/b.css", "b{}"), crawler.Meta{Name: "a.com/b.css"}); err == nil {
+		t.Error("expected error after exhausted attempts")
+	}
+}
@@ -0,0 +1,198 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHostRPS is default max requests per second for single host
+	DefaultHostRPS = 20
+	// DefaultHostConns is default max concurrent connections for single host
+	DefaultHostConns = 20
+
+	minBackoff = 1 * time.Second
+	maxBackoff = 2 * time.Minute
+)
+
+// hostScheduler is http.RoundTripper which limits requests rate and
+// concurrent connections per host, honors robots.txt Crawl-delay and
+// delays next requests to host on 429 and 503 responses. Requests are
+// not repeated, failed requests are retried by crawler RetryPolicy.
+type hostScheduler struct {
+	next http.RoundTripper
+
+	rps   float64
+	conns int
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+// hostSlot is single host scheduling state
+type hostSlot struct {
+	sem chan struct{}
+
+	mu         sync.Mutex
+	nextAt     time.Time
+	crawlDelay time.Duration
+	backoff    time.Duration
+}
+
+func newHostScheduler(next http.RoundTripper) *hostScheduler {
+	return &hostScheduler{
+		next:  next,
+		rps:   DefaultHostRPS,
+		conns: DefaultHostConns,
+		hosts: make(map[string]*hostSlot),
+	}
+}
+
+// configure limits, zero value disables the limit
+func (s *hostScheduler) configure(rps float64, conns int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rps = rps
+	s.conns = conns
+}
+
+// SetCrawlDelay set min interval between requests to the host
+func (s *hostScheduler) SetCrawlDelay(host string, d time.Duration) {
+	h := s.slot(host)
+	h.mu.Lock()
+	h.crawlDelay = d
+	h.mu.Unlock()
+}
+
+// RoundTrip wait for host slot and execute request, next requests
+// to host are delayed by Retry-After or backoff on 429 and 503 responses
+func (s *hostScheduler) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := s.slot(req.URL.Host)
+
+	ctx := req.Context()
+	if h.sem != nil {
+		select {
+		case h.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-h.sem }()
+	}
+
+	if err := sleepContext(ctx, h.reserve(s.interval())); err != nil {
+		return nil, err
+	}
+	res, err := s.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+		h.delay(retryAfter(res))
+	} else {
+		h.resetBackoff()
+	}
+	return res, nil
+}
+
+func (s *hostScheduler) interval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rps <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / s.rps)
+}
+
+func (s *hostScheduler) slot(host string) *hostSlot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[host]
+	if !ok {
+		h = &hostSlot{}
+		if s.conns > 0 {
+			h.sem = make(chan struct{}, s.conns)
+		}
+		s.hosts[host] = h
+	}
+	return h
+}
+
+// reserve next request time and return duration to wait for it
+func (h *hostSlot) reserve(interval time.Duration) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.crawlDelay > interval {
+		interval = h.crawlDelay
+	}
+	now := time.Now()
+	at := h.nextAt
+	if at.Before(now) {
+		at = now
+	}
+	h.nextAt = at.Add(interval)
+	return at.Sub(now)
+}
+
+// delay next requests to host by d or by growing backoff if d is zero
+func (h *hostSlot) delay(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if d <= 0 {
+		if h.backoff == 0 {
+			h.backoff = minBackoff
+		} else {
+			h.backoff *= 2
+		}
+		if h.backoff > maxBackoff {
+			h.backoff = maxBackoff
+		}
+		d = h.backoff
+	}
+	if at := time.Now().Add(d); at.After(h.nextAt) {
+		h.nextAt = at
+	}
+}
+
+func (h *hostSlot) resetBackoff() {
+	h.mu.Lock()
+	h.backoff = 0
+	h.mu.Unlock()
+}
+
+// sleepContext pause current goroutine for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfter parse Retry-After header in seconds or http date format
+func retryAfter(res *http.Response) time.Duration {
+	v := res.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	var d time.Duration
+	if sec, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(sec) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(time.Now())
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,177 @@
+package crawler_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chapsuk/crawler"
+)
+
+func get(t *testing.T, client *http.Client, u string) int {
+	res, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestSchedulerRate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: crawler.NewHostScheduler(http.DefaultTransport, 10, 0)}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		get(t, client, srv.URL)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("expected 5 requests at 10 rps take 400ms at least, gotten %s", d)
+	}
+}
+
+func TestSchedulerConns(t *testing.T) {
+	var cur, max int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&cur, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&cur, -1)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: crawler.NewHostScheduler(http.DefaultTransport, 0, 2)}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get(t, client, srv.URL)
+		}()
+	}
+	wg.Wait()
+	if max != 2 {
+		t.Errorf("expected 2 concurrent connections, gotten %d", max)
+	}
+}
+
+func TestSchedulerRetryAfter(t *testing.T) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: crawler.NewHostScheduler(http.DefaultTransport, 0, 0)}
+	if code := get(t, client, srv.URL); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 is not repeated, gotten %d", code)
+	}
+	start := time.Now()
+	if code := get(t, client, srv.URL); code != http.StatusOK || n != 2 {
+		t.Errorf("expected 200 for the next request, gotten %d after %d requests", code, n)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("expected next request after 1s, gotten %s", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":        0,
+		"3":       3 * time.Second,
+		"invalid": 0,
+		"100000":  2 * time.Minute,
+	}
+	for v, expected := range cases {
+		res := &http.Response{Header: http.Header{"Retry-After": {v}}}
+		if d := crawler.RetryAfter(res); d != expected {
+			t.Errorf("%q: expected %s, gotten %s", v, expected, d)
+		}
+	}
+
+	at := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	res := &http.Response{Header: http.Header{"Retry-After": {at}}}
+	if d := crawler.RetryAfter(res); d < 58*time.Second || d > time.Minute {
+		t.Errorf("http date: expected about 1m, gotten %s", d)
+	}
+}
+
+func TestHostBackoff(t *testing.T) {
+	delays := crawler.HostBackoff(10)
+	expected := []time.Duration{1, 2, 4, 8, 16, 32, 64, 120, 120, 120}
+	for i, d := range delays {
+		if d != expected[i]*time.Second {
+			t.Errorf("backoff %d: expected %ds, gotten %s", i, expected[i], d)
+		}
+	}
+}
+
+func TestSchedulerCrawlDelay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	s := crawler.NewHostScheduler(http.DefaultTransport, 0, 0)
+	s.SetCrawlDelay(u.Host, 200*time.Millisecond)
+	client := &http.Client{Transport: s}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		get(t, client, srv.URL)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("expected 3 requests with 200ms crawl delay take 400ms at least, gotten %s", d)
+	}
+}
+
+// TestRobotsRedirectCrawlDelay check Crawl-delay of redirected robots.txt
+// is applied to crawled host
+func TestRobotsRedirectCrawlDelay(t *testing.T) {
+	robots := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: *\nCrawl-delay: 0.3\n")
+	}))
+	defer robots.Close()
+
+	var mu sync.Mutex
+	var times []time.Time
+	tc := newTestCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.Redirect(w, r, robots.URL+"/robots.txt", http.StatusMovedPermanently)
+			return
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/b">b</a></body></html>`)
+		default:
+			fmt.Fprint(w, `<html><body>page</body></html>`)
+		}
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+	})
+	defer tc.close()
+
+	tc.run(func(c *crawler.Crawler) {
+		c.RespectRobots = true
+		c.MaxRequestsPerSecond = 0
+	})
+
+	if len(times) != 3 {
+		t.Fatalf("expected 3 pages, gotten %d", len(times))
+	}
+	if d := times[2].Sub(times[0]); d < 550*time.Millisecond {
+		t.Errorf("expected crawl delay between pages, 3 pages are fetched in %s", d)
+	}
+}
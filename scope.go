@@ -0,0 +1,73 @@
+package crawler
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Scope decide which hosts belong to the crawl
+type Scope struct {
+	host     string
+	domain   string
+	patterns []string
+}
+
+// NewScope return scope which contains the main host, hosts matched by
+// patterns and, if subDomains is true, all hosts with the same registrable
+// domain as the main host according to the Public Suffix List.
+// Pattern is exact host, *.domain wildcard which matches the domain
+// and all its subdomains or * which matches any host.
+func NewScope(mainHost string, subDomains bool, patterns ...string) *Scope {
+	s := &Scope{host: strings.ToLower(mainHost)}
+	if subDomains {
+		s.domain = registrableDomain(hostname(s.host))
+	}
+	for _, p := range patterns {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			s.patterns = append(s.patterns, p)
+		}
+	}
+	return s
+}
+
+// Contains return true if host is in scope
+func (s *Scope) Contains(host string) bool {
+	host = strings.ToLower(host)
+	if host == s.host {
+		return true
+	}
+
+	name := hostname(host)
+	if s.domain != "" && registrableDomain(name) == s.domain {
+		return true
+	}
+	for _, p := range s.patterns {
+		if p == "*" || p == host || matchHost(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// registrableDomain return eTLD+1 of host or empty string for ip
+// addresses and public suffixes
+func registrableDomain(host string) string {
+	if host == "" || net.ParseIP(host) != nil {
+		return ""
+	}
+	d, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return ""
+	}
+	return d
+}
+
+// hostname return host without port
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return strings.Trim(h, "[]")
+	}
+	return strings.Trim(host, "[]")
+}
@@ -0,0 +1,44 @@
+package crawler_test
+
+import (
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestScope(t *testing.T) {
+	cases := []struct {
+		name     string
+		scope    *crawler.Scope
+		host     string
+		expected bool
+	}{
+		{"main host", crawler.NewScope("example.com", false), "example.com", true},
+		{"case", crawler.NewScope("example.com", false), "EXAMPLE.com", true},
+		{"subdomain without flag", crawler.NewScope("example.com", false), "www.example.com", false},
+		{"subdomain", crawler.NewScope("example.com", true), "www.example.com", true},
+		{"deep subdomain", crawler.NewScope("example.com", true), "a.b.example.com", true},
+		{"sibling subdomain", crawler.NewScope("www.example.com", true), "static.example.com", true},
+		{"registrable domain", crawler.NewScope("www.example.com", true), "example.com", true},
+		{"suffix attack", crawler.NewScope("example.com", true), "example.com.evil.net", false},
+		{"prefix attack", crawler.NewScope("example.com", true), "notexample.com", false},
+		{"multi label suffix", crawler.NewScope("a.example.co.uk", true), "b.example.co.uk", true},
+		{"other under multi label suffix", crawler.NewScope("example.co.uk", true), "other.co.uk", false},
+		{"private suffix", crawler.NewScope("alice.github.io", true), "bob.github.io", false},
+		{"port", crawler.NewScope("example.com:8080", true), "www.example.com:8081", true},
+		{"other port", crawler.NewScope("example.com:8080", false), "example.com:8081", false},
+		{"ip", crawler.NewScope("127.0.0.1:8080", true), "127.0.0.2:8080", false},
+		{"explicit host", crawler.NewScope("example.com", false, "static.example.net"), "static.example.net", true},
+		{"explicit host only", crawler.NewScope("example.com", false, "static.example.net"), "a.static.example.net", false},
+		{"wildcard", crawler.NewScope("example.com", false, "*.example.net"), "a.b.example.net", true},
+		{"wildcard domain", crawler.NewScope("example.com", false, "*.example.net"), "example.net", true},
+		{"wildcard attack", crawler.NewScope("example.com", false, "*.example.net"), "badexample.net", false},
+		{"any host", crawler.NewScope("example.com", false, "*"), "cdn.jsdelivr.net", true},
+	}
+
+	for _, c := range cases {
+		if got := c.scope.Contains(c.host); got != c.expected {
+			t.Errorf("%s: contains %s expected %t, gotten %t", c.name, c.host, c.expected, got)
+		}
+	}
+}
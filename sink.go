@@ -0,0 +1,291 @@
+package crawler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Meta is response metadata of saved file
+type Meta struct {
+	// Name is relative file path built from url
+	Name         string
+	StatusCode   int
+	ContentType  string
+	ETag         string
+	LastModified string
+	// Hash is content hash of file which should be deduplicated,
+	// empty hash disables dedup of the file
+	Hash string
+}
+
+// Sink store saved files
+type Sink interface {
+	Write(f File, m Meta) error
+	Close() error
+}
+
+// fileChecker is implemented by sinks which keep files between runs
+type fileChecker interface {
+	Exists(name string) bool
+}
+
+var (
+	_ Sink        = new(DirSink)
+	_ fileChecker = new(DirSink)
+	_ Sink        = new(TarSink)
+	_ Sink        = new(ZipSink)
+)
+
+var errOutsideRoot = errors.New("file path is outside of output root")
+
+// convertedFile is file with rewritten body
+type convertedFile struct {
+	File
+	body []byte
+}
+
+func (f *convertedFile) GetBody() []byte {
+	return f.body
+}
+
+// DirSink write files to directory tree, files are gzipped with .gz
+// suffix if gzip is enabled. Deduplicated files are hardlinks
+// to the first file with the same content.
+type DirSink struct {
+	root string
+	gzip bool
+
+	files map[string]*storedFile
+	mu    sync.Mutex
+}
+
+// storedFile is the first written file with content hash
+type storedFile struct {
+	once sync.Once
+	path string
+	err  error
+}
+
+// NewDirSink return sink which write files to root directory
+func NewDirSink(root string, gzip bool) *DirSink {
+	return &DirSink{
+		root:  root,
+		gzip:  gzip,
+		files: make(map[string]*storedFile),
+	}
+}
+
+// Write file to path built from root and meta name
+func (s *DirSink) Write(f File, m Meta) error {
+	path, err := s.path(m.Name)
+	if err != nil {
+		return err
+	}
+	dir, _ := filepath.Split(path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, 0744); err != nil {
+			return err
+		}
+	}
+
+	body := f.GetBody()
+	if s.gzip {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		w.Write(body)
+		w.Close()
+		body = b.Bytes()
+	}
+	if m.Hash == "" {
+		return writeFile(path, body)
+	}
+
+	s.mu.Lock()
+	sf, ok := s.files[m.Hash]
+	if !ok {
+		sf = &storedFile{path: path}
+		s.files[m.Hash] = sf
+	}
+	s.mu.Unlock()
+
+	sf.once.Do(func() {
+		sf.err = writeFile(sf.path, body)
+	})
+	if sf.path == path {
+		return sf.err
+	}
+	if sf.err == nil {
+		os.Remove(path)
+		if os.Link(sf.path, path) == nil {
+			return nil
+		}
+	}
+	return writeFile(path, body)
+}
+
+// Exists return true if file with name is written
+func (s *DirSink) Exists(name string) bool {
+	path, err := s.path(name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// Close do nothing, files are closed after write
+func (s *DirSink) Close() error {
+	return nil
+}
+
+// path return file path of name, names which resolve
+// outside of root are rejected
+func (s *DirSink) path(name string) (string, error) {
+	path := filepath.Join(s.root, name)
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errOutsideRoot
+	}
+	if s.gzip {
+		path += ".gz"
+	}
+	return path, nil
+}
+
+// writeFile write to temp file and rename it, so interrupted crawl
+// never leaves half-written files
+func writeFile(path string, body []byte) error {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, body, 0666)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	return err
+}
+
+// TarSink write files to tar archive, optionally gzipped.
+// Deduplicated files are hardlink entries to the first file
+// with the same content.
+type TarSink struct {
+	f  *os.File
+	gz *gzip.Writer
+	tw *tar.Writer
+
+	names map[string]string
+	mu    sync.Mutex
+}
+
+// NewTarSink create tar archive at path
+func NewTarSink(path string, compress bool) (*TarSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &TarSink{f: f, names: make(map[string]string)}
+	var w io.Writer = f
+	if compress {
+		s.gz = gzip.NewWriter(f)
+		w = s.gz
+	}
+	s.tw = tar.NewWriter(w)
+	return s, nil
+}
+
+// Write file entry to archive
+func (s *TarSink) Write(f File, m Meta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hdr := &tar.Header{
+		Name:    filepath.ToSlash(m.Name),
+		Mode:    0644,
+		ModTime: time.Now(),
+	}
+	if first, ok := s.names[m.Hash]; ok && m.Hash != "" {
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = first
+		return s.tw.WriteHeader(hdr)
+	}
+	if m.Hash != "" {
+		s.names[m.Hash] = hdr.Name
+	}
+
+	body := f.GetBody()
+	hdr.Typeflag = tar.TypeReg
+	hdr.Size = int64(len(body))
+	if err := s.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := s.tw.Write(body)
+	return err
+}
+
+// Close finish archive
+func (s *TarSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.tw.Close()
+	if s.gz != nil {
+		if e := s.gz.Close(); err == nil {
+			err = e
+		}
+	}
+	if e := s.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// ZipSink write deflated files to zip archive,
+// zip has no links so duplicates are written as is
+type ZipSink struct {
+	f  *os.File
+	zw *zip.Writer
+	mu sync.Mutex
+}
+
+// NewZipSink create zip archive at path
+func NewZipSink(path string) (*ZipSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ZipSink{f: f, zw: zip.NewWriter(f)}, nil
+}
+
+// Write file entry to archive
+func (s *ZipSink) Write(f File, m Meta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hdr := &zip.FileHeader{
+		Name:   filepath.ToSlash(m.Name),
+		Method: zip.Deflate,
+	}
+	hdr.SetModTime(time.Now())
+	w, err := s.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(f.GetBody())
+	return err
+}
+
+// Close finish archive
+func (s *ZipSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.zw.Close()
+	if e := s.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package crawler_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func newTestAsset(t *testing.T, url, body string) crawler.File {
+	a, err := crawler.NewAsset(url, &http.Response{
+		Header: http.Header{},
+		Body:   ioutil.NopCloser(strings.NewReader(body)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestArchiveSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tarPath := filepath.Join(dir, "out.tar.gz")
+	ts, err := crawler.NewTarSink(tarPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "out.zip")
+	zs, err := crawler.NewZipSink(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []crawler.Sink{ts, zs} {
+		s.Write(newTestAsset(t, "http://a.com/a.css", "body"), crawler.Meta{Name: "a.com/a.css", Hash: "h"})
+		s.Write(newTestAsset(t, "http://a.com/b.css", "body"), crawler.Meta{Name: "a.com/b.css", Hash: "h"})
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var entries []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, hdr.Name+">"+hdr.Linkname)
+	}
+	if got := strings.Join(entries, " "); got != "a.com/a.css> a.com/b.css>a.com/a.css" {
+		t.Errorf("unexpected tar entries %q", got)
+	}
+
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 2 || zr.File[1].Name != "a.com/b.css" {
+		t.Fatalf("unexpected zip entries %v", zr.File)
+	}
+	r, _ := zr.File[1].Open()
+	b, _ := ioutil.ReadAll(r)
+	if string(b) != "body" {
+		t.Errorf("expected body, gotten %q", b)
+	}
+}
+
+func TestDirSinkOutsideRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "out")
+	s := crawler.NewDirSink(root, false)
+	f := newTestAsset(t, "http://a.com/a.css", "body")
+	if err := s.Write(f, crawler.Meta{Name: "a.com/../../escaped.css"}); err == nil {
+		t.Error("expected error on write outside of root")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.css")); !os.IsNotExist(err) {
+		t.Errorf("file written outside of root: %v", err)
+	}
+	if err := s.Write(f, crawler.Meta{Name: "a.com/b/../a.css"}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Exists("a.com/a.css") {
+		t.Error("expected a.com/a.css in root")
+	}
+}
@@ -0,0 +1,155 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+)
+
+const (
+	sitemapMaxSize  = 50 * 1024 * 1024
+	sitemapMaxDepth = 3
+	sitemapMaxCount = 1000
+)
+
+// SitemapEntry is url or child sitemap location with last modification time
+type SitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// ParseSitemap parse urlset or sitemapindex document, gzipped documents
+// are detected by magic bytes. Return page urls and child sitemaps.
+func ParseSitemap(r io.Reader) (urls []SitemapEntry, sitemaps []SitemapEntry, err error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	dec := xml.NewDecoder(r)
+	// sitemaps are utf-8 by protocol, other charsets are read as is
+	dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return urls, sitemaps, nil
+		}
+		if err != nil {
+			return urls, sitemaps, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || (se.Name.Local != "url" && se.Name.Local != "sitemap") {
+			continue
+		}
+
+		var e SitemapEntry
+		if err := dec.DecodeElement(&e, &se); err != nil {
+			return urls, sitemaps, err
+		}
+		e.Loc = strings.TrimSpace(e.Loc)
+		e.LastMod = strings.TrimSpace(e.LastMod)
+		if e.Loc == "" {
+			continue
+		}
+		if se.Name.Local == "url" {
+			urls = append(urls, e)
+		} else {
+			sitemaps = append(sitemaps, e)
+		}
+	}
+}
+
+// enqueSitemaps enqueue pages listed in /sitemap.xml and robots.txt
+// Sitemap: lines of the seed host
+func (c *Crawler) enqueSitemaps(seed string) {
+	u, err := url.Parse(seed)
+	if err != nil {
+		return
+	}
+	root := u.Scheme + "://" + u.Host
+
+	locs := []string{root + "/sitemap.xml"}
+	if r := c.getRobots(u); r != nil {
+		locs = append(locs, r.Sitemaps...)
+	}
+
+	seen := make(map[string]bool)
+	for _, loc := range locs {
+		c.enqueSitemap(loc, 0, seen)
+	}
+}
+
+func (c *Crawler) enqueSitemap(loc string, depth int, seen map[string]bool) {
+	if seen[loc] || len(seen) >= sitemapMaxCount || c.isCanceled() {
+		return
+	}
+	seen[loc] = true
+
+	urls, sitemaps, err := c.fetchSitemap(loc)
+	if err != nil {
+		log.Printf("sitemap %s error: %s", loc, err)
+	}
+
+	for _, e := range urls {
+		u, err := c.normalizeURL(e.Loc, c.scope)
+		if err != nil {
+			continue
+		}
+		c.enqueUploadPage(u, loc, 0)
+		if e.LastMod != "" {
+			c.state.SetLastMod(u, e.LastMod)
+		}
+	}
+
+	if depth >= sitemapMaxDepth {
+		return
+	}
+	for _, e := range sitemaps {
+		c.enqueSitemap(resolveURL(loc, e.Loc), depth+1, seen)
+	}
+}
+
+func (c *Crawler) fetchSitemap(loc string) ([]SitemapEntry, []SitemapEntry, error) {
+	for i := 0; ; i++ {
+		req, err := c.craeteRequest(loc)
+		if err != nil {
+			return nil, nil, err
+		}
+		res, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isRedirectStatus(res.StatusCode) && i < maxRedirects {
+			res.Body.Close()
+			l, err := res.Location()
+			if err != nil {
+				return nil, nil, err
+			}
+			loc = l.String()
+			continue
+		}
+
+		defer res.Body.Close()
+		if !isSuccessStatus(res.StatusCode) {
+			// missing sitemap.xml is common, it is not an error
+			if res.StatusCode == 404 {
+				return nil, nil, nil
+			}
+			return nil, nil, statusError{res.StatusCode}
+		}
+		return ParseSitemap(io.LimitReader(res.Body, sitemapMaxSize))
+	}
+}
@@ -0,0 +1,57 @@
+package crawler_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestParseSitemap(t *testing.T) {
+	urlset := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc> https://example.com/ </loc><lastmod>2017-01-02</lastmod></url>
+	<url><loc>https://example.com/about</loc></url>
+	<url><loc></loc></url>
+</urlset>`
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(urlset))
+	w.Close()
+
+	for _, r := range []*bytes.Reader{bytes.NewReader([]byte(urlset)), bytes.NewReader(gz.Bytes())} {
+		urls, sitemaps, err := crawler.ParseSitemap(r)
+		if err != nil {
+			t.Fatalf("parse urlset error: %s", err)
+		}
+		if len(sitemaps) != 0 {
+			t.Errorf("expected no sitemaps, gotten %v", sitemaps)
+		}
+		expected := []crawler.SitemapEntry{
+			{Loc: "https://example.com/", LastMod: "2017-01-02"},
+			{Loc: "https://example.com/about"},
+		}
+		if len(urls) != len(expected) {
+			t.Fatalf("expected %v, gotten %v", expected, urls)
+		}
+		for i := range expected {
+			if urls[i] != expected[i] {
+				t.Errorf("expected %v, gotten %v", expected[i], urls[i])
+			}
+		}
+	}
+
+	index := `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>https://example.com/sitemap-1.xml.gz</loc></sitemap>
+</sitemapindex>`
+	urls, sitemaps, err := crawler.ParseSitemap(strings.NewReader(index))
+	if err != nil {
+		t.Fatalf("parse index error: %s", err)
+	}
+	if len(urls) != 0 || len(sitemaps) != 1 || sitemaps[0].Loc != "https://example.com/sitemap-1.xml.gz" {
+		t.Errorf("unexpected index result: %v %v", urls, sitemaps)
+	}
+}
@@ -2,6 +2,8 @@ package crawler
 
 import (
 	"errors"
+	"log"
+	"net/http"
 	"sync"
 )
 
@@ -12,6 +14,28 @@ type ItemType int
 type Item struct {
 	status Status
 	itype  ItemType
+	// depth is count of links from start endpoint
+	depth int
+	// attempts is count of failed uploads and lastErr is the last failure
+	attempts int
+	lastErr  string
+	// httpStatus is the last response status code and
+	// redirect is response location for redirect statuses
+	httpStatus int
+	redirect   string
+	// lastMod is last modification time from sitemap
+	lastMod string
+	// etag and modified are ETag and Last-Modified headers of the saved
+	// response, they are sent as conditions on revisit
+	etag     string
+	modified string
+	// hash is sha256 of response body
+	hash string
+	// contentType and size are Content-Type and body size of response
+	contentType string
+	size        int
+	// parent is url of page where url is found
+	parent string
 }
 
 type State struct {
@@ -20,16 +44,65 @@ type State struct {
 	mu       sync.Mutex
 	wg       sync.WaitGroup
 	storage  Storage
+	// store keeps items which are not in flight, if it is not nil
+	// progress contains in flight items only
+	store ProgressStore
+
+	// counts of fetched urls by type and limits for them
+	counts map[ItemType]int
+	limits map[ItemType]int
+	bytes  int64
+
+	// revisited is content hashes of urls saved by previous run
+	// and revisited by current one
+	revisited map[string]string
+	// hashes is first url with content hash
+	hashes map[string]string
 }
 
+// ProgressStore is Storage which is authoritative set of visited urls,
+// State keeps in memory only urls in flight and looks up other urls
+// and content hashes in the store
+type ProgressStore interface {
+	Storage
+	Get(url string) (Item, bool)
+	ForEach(fn func(url string, i Item))
+	// FirstURL return url with content hash, url is set as the first
+	// one if hash is new
+	FirstURL(hash, url string) string
+}
+
+// Changes is result of incremental crawl compared with previous run
+type Changes struct {
+	New       int
+	Changed   int
+	Unchanged int
+	Removed   int
+}
+
+// maxRedirects is max length of followed redirects chain
+const maxRedirects = 10
+
 var (
 	errHasMoreOrEqualStatus = errors.New("has more or equal status")
+	errLimitReached         = errors.New("limit reached")
 )
 
 const (
 	InFlightStatus Status = 1 + iota
 	IgnoreStatus
 	SavedStatus
+	// DisallowedStatus is set for urls disallowed by robots.txt
+	DisallowedStatus
+	// LimitedStatus is set for urls beyond crawl depth, count or size limits
+	LimitedStatus
+	// FailedStatus is set for urls with non-2xx response
+	FailedStatus
+	// RedirectStatus is set for urls with redirect response,
+	// redirect location is processed as separate url
+	RedirectStatus
+	// FilteredStatus is set for urls rejected by url filter
+	FilteredStatus
 )
 
 const (
@@ -39,13 +112,48 @@ const (
 
 // NewState return new state instance
 func NewState(s Storage) *State {
+	ps, _ := s.(ProgressStore)
 	return &State{
-		progress: make(map[string]Item),
-		storage:  s,
-		empty:    true,
+		store:     ps,
+		progress:  make(map[string]Item),
+		storage:   s,
+		empty:     true,
+		counts:    make(map[ItemType]int),
+		limits:    make(map[ItemType]int),
+		revisited: make(map[string]string),
+		hashes:    make(map[string]string),
 	}
 }
 
+// SetLimit set max count of fetched urls with type t, zero is unlimited
+func (s *State) SetLimit(t ItemType, n int) {
+	s.mu.Lock()
+	s.limits[t] = n
+	s.mu.Unlock()
+}
+
+// AddBytes increase downloaded bytes counter
+func (s *State) AddBytes(n int) {
+	s.mu.Lock()
+	s.bytes += int64(n)
+	s.mu.Unlock()
+}
+
+// Bytes return count of bytes downloaded in current run
+func (s *State) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// Depth return url depth or zero if url not in progress
+func (s *State) Depth(url string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, _ := s.get(url)
+	return i.depth
+}
+
 // Close call storage close is storage not nil
 func (s *State) Close() error {
 	if s.storage != nil {
@@ -64,12 +172,24 @@ func (s *State) IsEmpty() bool {
 	return s.empty
 }
 
-// MarkAsInFlight set inFlight status and save it to storage
-func (s *State) MarkAsInFlight(url string, t ItemType) error {
+// MarkAsInFlight set inFlight status for new or limited url and save it
+// to storage, return errLimitReached if count limit for the type is reached
+func (s *State) MarkAsInFlight(url string, t ItemType, depth int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// limited url could be found again within limits
+	if i, ok := s.get(url); ok && i.status != LimitedStatus {
+		return errHasMoreOrEqualStatus
+	}
+	if l := s.limits[t]; l > 0 && s.counts[t] >= l {
+		return errLimitReached
+	}
 	s.wg.Add(1)
-	if err := s.setStatus(url, t, InFlightStatus); err != nil {
-		s.wg.Done()
-		return err
+	s.counts[t]++
+	// url is in progress and will be processed, so storage error
+	// is not returned, status is stored with the next change
+	if err := s.put(url, Item{status: InFlightStatus, itype: t, depth: depth}); err != nil {
+		log.Printf("store in flight status of %s error: %s", url, err)
 	}
 	return nil
 }
@@ -86,34 +206,308 @@ func (s *State) MarkAsIgnored(url string, t ItemType) error {
 	return s.setStatus(url, t, IgnoreStatus)
 }
 
+// MarkAsFailed set failed status and save it to storage
+func (s *State) MarkAsFailed(url string, t ItemType) error {
+	defer s.wg.Done()
+	return s.setStatus(url, t, FailedStatus)
+}
+
+// MarkAsRedirected set redirect status and save it to storage
+func (s *State) MarkAsRedirected(url string, t ItemType) error {
+	defer s.wg.Done()
+	return s.setStatus(url, t, RedirectStatus)
+}
+
+// SetResponse remember response status code and redirect location,
+// they are saved to storage with next status change
+func (s *State) SetResponse(url string, code int, redirect string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.progress[url]; ok {
+		i.httpStatus = code
+		i.redirect = redirect
+		s.progress[url] = i
+	}
+}
+
+// LocalURL follow recorded redirects from url and return the final url,
+// ok is true if the final url is saved or still in flight
+func (s *State) LocalURL(url string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < maxRedirects; i++ {
+		v, ok := s.get(url)
+		if !ok {
+			return url, false
+		}
+		switch v.status {
+		case InFlightStatus, SavedStatus:
+			return url, true
+		case RedirectStatus:
+			if v.redirect == "" {
+				return url, false
+			}
+			url = v.redirect
+		default:
+			return url, false
+		}
+	}
+	return url, false
+}
+
+// SetLastMod remember sitemap last modification time of url,
+// it is saved to storage with next status change
+func (s *State) SetLastMod(url string, lastMod string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.progress[url]; ok {
+		i.lastMod = lastMod
+		s.progress[url] = i
+	}
+}
+
+// SetContent remember content type and body size of response,
+// they are saved to storage with next status change
+func (s *State) SetContent(url string, contentType string, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.progress[url]; ok {
+		i.contentType = contentType
+		i.size = size
+		s.progress[url] = i
+	}
+}
+
+// SetParent remember url of page where in flight url is found,
+// it is saved to storage with next status change
+func (s *State) SetParent(url string, parent string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.progress[url]; ok {
+		i.parent = parent
+		s.progress[url] = i
+	}
+}
+
+// SetValidators remember ETag and Last-Modified of response,
+// they are saved to storage with next status change
+func (s *State) SetValidators(url string, etag, modified string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.progress[url]; ok {
+		i.etag = etag
+		i.modified = modified
+		s.progress[url] = i
+	}
+}
+
+// Validators return ETag and Last-Modified of saved response
+func (s *State) Validators(url string) (etag, modified string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, _ := s.get(url)
+	return i.etag, i.modified
+}
+
+// SetHash remember content hash of url, return url which is already
+// processed with the same hash or empty string if url is the first one
+func (s *State) SetHash(url string, hash string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.progress[url]
+	if !ok {
+		return ""
+	}
+	i.hash = hash
+	s.progress[url] = i
+	if s.store != nil {
+		if orig := s.store.FirstURL(hash, url); orig != url {
+			return orig
+		}
+		return ""
+	}
+	if orig, ok := s.hashes[hash]; ok && orig != url {
+		return orig
+	}
+	s.hashes[hash] = url
+	return ""
+}
+
+// Hash return content hash of url
+func (s *State) Hash(url string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, _ := s.get(url)
+	return i.hash
+}
+
+// Revisit set inFlight status for urls saved by previous run,
+// return count of revisited urls
+func (s *State) Revisit() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := make(map[string]Item)
+	s.forEach(func(url string, i Item) {
+		if i.status == SavedStatus {
+			saved[url] = i
+		}
+	})
+	for url, i := range saved {
+		s.wg.Add(1)
+		s.revisited[url] = i.hash
+		i.status = InFlightStatus
+		s.put(url, i)
+	}
+	return len(saved)
+}
+
+// Changes compare current run with previous one, url is unchanged
+// if it is not modified since previous run or its content hash is equal
+// to previous one and removed if it is saved by previous run but not
+// by current one
+func (s *State) Changes() Changes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var c Changes
+	s.forEach(func(url string, i Item) {
+		hash, revisited := s.revisited[url]
+		switch {
+		case i.status == InFlightStatus:
+		case i.status == SavedStatus && i.httpStatus == http.StatusNotModified:
+			c.Unchanged++
+		case i.status == SavedStatus && revisited && hash != "" && hash == i.hash:
+			c.Unchanged++
+		case i.status == SavedStatus && revisited:
+			c.Changed++
+		case i.status == SavedStatus:
+			c.New++
+		case revisited:
+			c.Removed++
+		}
+	})
+	return c
+}
+
+// HTTPStatus return last response status code for url
+func (s *State) HTTPStatus(url string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, _ := s.get(url)
+	return i.httpStatus
+}
+
+// AddAttempt record failed upload attempt and return failed attempts count
+func (s *State) AddAttempt(url string, err error) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, _ := s.get(url)
+	i.attempts++
+	if err != nil {
+		i.lastErr = err.Error()
+	}
+	s.put(url, i)
+	return i.attempts
+}
+
+// MarkAsDisallowed set disallowed status for url which not in progress yet
+func (s *State) MarkAsDisallowed(url string, t ItemType, depth int) error {
+	return s.setStatusIfNew(url, Item{status: DisallowedStatus, itype: t, depth: depth})
+}
+
+// MarkAsLimited set limited status for url which not in progress yet
+func (s *State) MarkAsLimited(url string, t ItemType, depth int) error {
+	return s.setStatusIfNew(url, Item{status: LimitedStatus, itype: t, depth: depth})
+}
+
+// MarkAsFiltered set filtered status for new or in flight url
+func (s *State) MarkAsFiltered(url string, t ItemType, depth int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.get(url)
+	switch {
+	case !ok:
+		i = Item{itype: t, depth: depth}
+	case i.status == InFlightStatus:
+		s.wg.Done()
+	default:
+		return errHasMoreOrEqualStatus
+	}
+	i.status = FilteredStatus
+	return s.put(url, i)
+}
+
+// set item only if url not in progress and store it to storage,
+// work group is not affected
+func (s *State) setStatusIfNew(url string, i Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.get(url); ok {
+		return errHasMoreOrEqualStatus
+	}
+	return s.put(url, i)
+}
+
 // set status and store it to storage
 func (s *State) setStatus(url string, t ItemType, sts Status) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if v, ok := s.progress[url]; ok {
-		if v.status >= sts {
-			return errHasMoreOrEqualStatus
-		}
+	i, ok := s.get(url)
+	if ok && i.status >= sts {
+		return errHasMoreOrEqualStatus
+	}
+	i.status = sts
+	i.itype = t
+	return s.put(url, i)
+}
+
+// get return item from memory or from progress store
+func (s *State) get(url string) (Item, bool) {
+	if i, ok := s.progress[url]; ok {
+		return i, true
+	}
+	if s.store != nil {
+		return s.store.Get(url)
+	}
+	return Item{}, false
+}
+
+// put set item and store it to storage, with progress store
+// only in flight items are kept in memory
+func (s *State) put(url string, i Item) error {
+	if s.store != nil && i.status != InFlightStatus {
+		delete(s.progress, url)
+	} else {
+		s.progress[url] = i
 	}
-	s.progress[url] = Item{status: sts, itype: t}
 	if s.storage != nil {
-		return s.storage.SetStatus(url, t, sts)
+		return s.storage.SetStatus(url, i)
 	}
 	return nil
 }
 
+// forEach call fn for all items
+func (s *State) forEach(fn func(url string, i Item)) {
+	if s.store != nil {
+		s.store.ForEach(fn)
+		return
+	}
+	for url, i := range s.progress {
+		fn(url, i)
+	}
+}
+
 // IsSaved return true if page with url saved or mark as ignored
 func (s *State) IsSaved(url string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if v, ok := s.progress[url]; ok {
-		return v.status == SavedStatus
-	}
-	return false
+	v, ok := s.get(url)
+	return ok && v.status == SavedStatus
 }
 
 // GetInflight return inFlight items
 func (s *State) GetInflight() map[string]Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	res := make(map[string]Item)
 	for k, v := range s.progress {
 		if v.status == InFlightStatus {
@@ -123,10 +517,37 @@ func (s *State) GetInflight() map[string]Item {
 	return res
 }
 
+// GetLimited return urls which are beyond limits
+func (s *State) GetLimited() map[string]Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]Item)
+	s.forEach(func(url string, i Item) {
+		if i.status == LimitedStatus {
+			res[url] = i
+		}
+	})
+	return res
+}
+
 // AddProgress item
 func (s *State) AddProgress(url string, i Item) {
-	if i.status == InFlightStatus {
+	switch i.status {
+	case InFlightStatus:
 		s.wg.Add(1)
+		s.counts[i.itype]++
+	case SavedStatus, IgnoreStatus:
+		s.counts[i.itype]++
+	}
+	if s.store != nil {
+		// progress store keeps items and hashes itself
+		if i.status == InFlightStatus {
+			s.progress[url] = i
+		}
+		return
+	}
+	if _, ok := s.hashes[i.hash]; !ok && i.hash != "" && i.status == SavedStatus {
+		s.hashes[i.hash] = url
 	}
 	s.progress[url] = i
 }
@@ -0,0 +1,36 @@
+package crawler_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+// TestGetInflightConcurrent check in flight urls are listed while
+// workers mark new urls, run with -race
+func TestGetInflightConcurrent(t *testing.T) {
+	state := crawler.NewState(nil)
+	for i := 0; i < 100; i++ {
+		state.MarkAsInFlight(fmt.Sprintf("http://a.com/%d", i), crawler.PageType, 0)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 100; i < 1000; i++ {
+			state.MarkAsInFlight(fmt.Sprintf("http://a.com/%d", i), crawler.PageType, 0)
+		}
+	}()
+	for n := 0; n < 10; n++ {
+		if l := len(state.GetInflight()); l < 100 {
+			t.Errorf("expected at least 100 urls in flight, gotten %d", l)
+		}
+	}
+	wg.Wait()
+	if l := len(state.GetInflight()); l != 1000 {
+		t.Errorf("expected 1000 urls in flight, gotten %d", l)
+	}
+}
@@ -0,0 +1,143 @@
+package crawler
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	statusFlushSize     = 1000
+	statusFlushInterval = 100 * time.Millisecond
+)
+
+// statusBuffer collect status changes and write them in batches by size
+// or interval, the last change of url in batch wins. Batches are written
+// one by one in order of changes and flush function must write batch
+// atomically, so after crash storage contains a prefix of changes:
+// urls found on saved page are stored at least in flight, because they
+// are marked in flight before the page is marked as saved.
+type statusBuffer struct {
+	flushFn func(items map[string]Item) error
+	size    int
+
+	mu       sync.Mutex
+	pending  map[string]Item
+	flushing map[string]Item
+	// flushMu serialize flushes
+	flushMu sync.Mutex
+
+	flushCh chan struct{}
+	closeCh chan struct{}
+	done    chan struct{}
+}
+
+// newStatusBuffer return buffer which flush statuses by fn
+// in background until close
+func newStatusBuffer(size int, interval time.Duration, fn func(items map[string]Item) error) *statusBuffer {
+	if size <= 0 {
+		size = statusFlushSize
+	}
+	if interval <= 0 {
+		interval = statusFlushInterval
+	}
+	b := &statusBuffer{
+		flushFn: fn,
+		size:    size,
+		pending: make(map[string]Item),
+		flushCh: make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go b.run(interval)
+	return b
+}
+
+// Set buffer status of url, flush errors are reported by Flush and Close
+func (b *statusBuffer) Set(url string, i Item) {
+	b.mu.Lock()
+	b.pending[url] = i
+	n := len(b.pending)
+	b.mu.Unlock()
+
+	if n >= b.size {
+		select {
+		case b.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Get return buffered status of url
+func (b *statusBuffer) Get(url string) (Item, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if i, ok := b.pending[url]; ok {
+		return i, true
+	}
+	i, ok := b.flushing[url]
+	return i, ok
+}
+
+// Reset drop buffered statuses
+func (b *statusBuffer) Reset() {
+	b.mu.Lock()
+	b.pending = make(map[string]Item)
+	b.mu.Unlock()
+}
+
+// Flush write buffered statuses, failed batch is kept in buffer
+// and written with the next flush
+func (b *statusBuffer) Flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	items := b.pending
+	if len(items) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	b.flushing = items
+	b.pending = make(map[string]Item)
+	b.mu.Unlock()
+
+	err := b.flushFn(items)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushing = nil
+	if err != nil {
+		// newer changes win over failed ones
+		for url, i := range items {
+			if _, ok := b.pending[url]; !ok {
+				b.pending[url] = i
+			}
+		}
+	}
+	return err
+}
+
+// Close stop background flushes and write buffered statuses
+func (b *statusBuffer) Close() error {
+	close(b.closeCh)
+	<-b.done
+	return b.Flush()
+}
+
+func (b *statusBuffer) run(interval time.Duration) {
+	defer close(b.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-b.flushCh:
+		case <-b.closeCh:
+			return
+		}
+		if err := b.Flush(); err != nil {
+			log.Printf("flush statuses error: %s", err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package crawler_test
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chapsuk/crawler"
+)
+
+type statusBuffer interface {
+	Set(url string, i crawler.Item)
+	Get(url string) (crawler.Item, bool)
+	Flush() error
+	Close() error
+}
+
+// bufferStorage is storage which write batches of status buffer
+type bufferStorage struct {
+	buf statusBuffer
+
+	mu      sync.Mutex
+	fail    bool
+	batches []map[string]crawler.Item
+}
+
+func newBufferStorage(size int, interval time.Duration) *bufferStorage {
+	s := &bufferStorage{}
+	s.buf = crawler.NewStatusBuffer(size, interval, s.write)
+	return s
+}
+
+func (s *bufferStorage) write(items map[string]crawler.Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("write error")
+	}
+	s.batches = append(s.batches, items)
+	return nil
+}
+
+func (s *bufferStorage) stored(n int) map[string]crawler.Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]crawler.Item)
+	for _, b := range s.batches[:n] {
+		for url, i := range b {
+			res[url] = i
+		}
+	}
+	return res
+}
+
+func (s *bufferStorage) Load() (*crawler.State, error) { return crawler.NewState(s), nil }
+func (s *bufferStorage) SetStatus(url string, i crawler.Item) error {
+	s.buf.Set(url, i)
+	return nil
+}
+func (s *bufferStorage) Close() error { return s.buf.Close() }
+
+func TestStatusBufferRetry(t *testing.T) {
+	s := newBufferStorage(100, time.Hour)
+	state := crawler.NewState(s)
+	state.MarkAsInFlight("http://a.com/", crawler.PageType, 0)
+	if i, ok := s.buf.Get("http://a.com/"); !ok || crawler.ItemStatus(i) != crawler.InFlightStatus {
+		t.Error("expected buffered status")
+	}
+
+	s.fail = true
+	if err := s.buf.Flush(); err == nil {
+		t.Fatal("expected flush error")
+	}
+	if err := s.SetStatus("http://a.com/b", crawler.Item{}); err != nil {
+		t.Errorf("expected set status ignore flush error, gotten %s", err)
+	}
+	state.MarkAsSaved("http://a.com/", crawler.PageType)
+
+	s.fail = false
+	if err := s.buf.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	stored := s.stored(len(s.batches))
+	if i, ok := stored["http://a.com/"]; !ok || crawler.ItemStatus(i) != crawler.SavedStatus {
+		t.Errorf("expected the last status of failed batch stored, got %v", stored)
+	}
+
+	state.MarkAsInFlight("http://a.com/c", crawler.PageType, 1)
+	if err := state.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.stored(len(s.batches))["http://a.com/c"]; !ok {
+		t.Error("expected buffered status stored on close")
+	}
+}
+
+// TestStatusBufferPrefix check storage is consistent after crash at
+// any batch: links of saved page are stored
+func TestStatusBufferPrefix(t *testing.T) {
+	s := newBufferStorage(3, time.Millisecond)
+	state := crawler.NewState(s)
+	page := "http://a.com/"
+	state.MarkAsInFlight(page, crawler.PageType, 0)
+	for i := 0; i < 1000; i++ {
+		link := page + strconv.Itoa(i)
+		state.MarkAsInFlight(link, crawler.PageType, i+1)
+		state.MarkAsSaved(page, crawler.PageType)
+		page = link
+	}
+	state.MarkAsSaved(page, crawler.PageType)
+	if err := state.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for n := range s.batches {
+		stored := s.stored(n + 1)
+		page := "http://a.com/"
+		for i := 0; i < 1000; i++ {
+			link := page + strconv.Itoa(i)
+			saved := crawler.ItemStatus(stored[page]) == crawler.SavedStatus
+			if _, ok := stored[link]; saved && !ok {
+				t.Fatalf("batch %d: %s saved without link %s", n, page, link)
+			}
+			page = link
+		}
+	}
+	if len(s.stored(len(s.batches))) != 1001 {
+		t.Error("expected all statuses stored")
+	}
+}
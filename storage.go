@@ -2,6 +2,7 @@ package crawler
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -10,85 +11,260 @@ import (
 
 type Storage interface {
 	Load() (*State, error)
-	SetStatus(url string, t ItemType, s Status) error
+	SetStatus(url string, i Item) error
 	Close() error
 }
 
 var _ Storage = new(PGStorage)
 
-// PGStorage write statuses to postgres
+// PGStorage write statuses to postgres in batches, each batch is copied
+// to temp table and merged to the table in one transaction, so after
+// crash the table contains statuses changed before the last flush.
+// Statuses are stored in table of main host and keyed by crawl id,
+// crawls are registered in crawls table.
 type PGStorage struct {
+	// CrawlID is id of loaded crawl, the last crawl of host is
+	// loaded if it is zero
+	CrawlID int64
+	// Seeds and Options are recorded for new crawl
+	Seeds   []string
+	Options map[string]string
+
 	db        *sql.DB
 	tableName string
+	buf       *statusBuffer
 }
 
-// NewPGStorage return new postgres storage instance
+// errUndefinedTable is postgres code of missing table error
+const errUndefinedTable = "42P01"
+
+// NewPGStorage return new postgres storage instance,
+// schema migrations are applied to the table of main host
 func NewPGStorage(connection string, mainHost string) (*PGStorage, error) {
 	db, err := sql.Open("postgres", connection)
 	if err != nil {
 		return nil, err
 	}
-	return &PGStorage{
+	pgs := &PGStorage{
 		db:        db,
 		tableName: mainHost,
-	}, nil
+	}
+	if err = pgs.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	pgs.buf = newStatusBuffer(statusFlushSize, statusFlushInterval, pgs.write)
+	return pgs, nil
 }
 
-// Close db connections
+// Close flush buffered statuses, record crawl stats
+// and close db connections
 func (pgs *PGStorage) Close() error {
-	return pgs.db.Close()
+	err := pgs.buf.Close()
+	if err == nil && pgs.CrawlID != 0 {
+		err = pgs.finish()
+	}
+	if e := pgs.db.Close(); err == nil {
+		err = e
+	}
+	return err
 }
 
-// Load state from db
+// Load state of crawl, new crawl is registered if host has no crawls
+// or table not exists
 func (pgs *PGStorage) Load() (*State, error) {
-	state := NewState(pgs)
+	state, err := pgs.load()
+	if isUndefinedTable(err) {
+		if err = pgs.migrate(); err != nil {
+			return nil, err
+		}
+		return pgs.Clear()
+	}
+	return state, err
+}
+
+func (pgs *PGStorage) load() (*State, error) {
+	if pgs.CrawlID == 0 {
+		err := pgs.db.QueryRow(queryLastCrawl, pgs.tableName).Scan(&pgs.CrawlID)
+		if err == sql.ErrNoRows {
+			return pgs.Clear()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	res, err := pgs.db.Exec(queryResumeCrawl, pgs.CrawlID, pgs.tableName)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, errCrawlNotFound
+	}
 
-	rws, err := pgs.db.Query(pgs.getQuery(queryGetAll))
+	state := NewState(pgs)
+	rws, err := pgs.db.Query(pgs.getQuery(queryGetAll), pgs.CrawlID)
 	if err != nil {
-		log.Printf("todo need catch table not exists error, gotten: %s", err)
 		return nil, err
 	}
+	defer rws.Close()
 
+	empty := true
 	var i Item
 	var url string
 	for rws.Next() {
-		err := rws.Scan(&url, &i.itype, &i.status)
+		err := rws.Scan(&url, &i.itype, &i.status, &i.depth, &i.attempts, &i.lastErr,
+			&i.httpStatus, &i.redirect, &i.lastMod, &i.etag, &i.modified, &i.hash,
+			&i.contentType, &i.size, &i.parent)
 		if err != nil {
 			return nil, err
 		}
 		state.AddProgress(url, i)
+		empty = false
+	}
+	if err = rws.Err(); err != nil {
+		return nil, err
 	}
-	state.SetEmpty(false)
+	state.SetEmpty(empty)
+	log.Printf("resume crawl %d", pgs.CrawlID)
 	return state, nil
 }
 
-// Clear truncate table adn return empty state
+// Clear register new crawl and return its empty state,
+// statuses of previous crawls are kept until they are deleted
 func (pgs *PGStorage) Clear() (*State, error) {
-	_, err := pgs.db.Query(pgs.getQuery(queryClearTable))
+	pgs.buf.Reset()
+	options := pgs.Options
+	if options == nil {
+		options = map[string]string{}
+	}
+	seeds, _ := json.Marshal(append([]string{}, pgs.Seeds...))
+	opts, _ := json.Marshal(options)
+	err := pgs.db.QueryRow(queryCreateCrawl, pgs.tableName, string(seeds), string(opts)).Scan(&pgs.CrawlID)
 	if err != nil {
-		if pgerr, ok := err.(*pq.Error); ok {
-			if pgerr.Code == "42P01" {
-				_, err = pgs.db.Query(pgs.getQuery(queryCreateTableTpl))
-				if err != nil {
-					log.Printf("create table error: %s", err)
-				}
-			}
-		} else {
-			log.Printf("truncate table error: %#v", err)
-		}
+		return nil, err
 	}
+	log.Printf("start crawl %d", pgs.CrawlID)
 	return NewState(pgs), nil
 }
 
-// SetStatus save status for concret url
-func (pgs *PGStorage) SetStatus(url string, t ItemType, s Status) error {
-	_, err := pgs.db.Exec(pgs.getQuery(querySetStatus), url, t, s)
+// migrate apply migrations which are not applied to the table yet
+func (pgs *PGStorage) migrate() error {
+	return pgs.migrateTo(len(migrations))
+}
+
+// migrateTo apply migrations up to version, migrations are applied
+// in one transaction under exclusive lock so crawlers could start
+// with the same database concurrently
+func (pgs *PGStorage) migrateTo(last int) error {
+	tx, err := pgs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, q := range []string{queryCreateMigrations, queryLockMigrations} {
+		if _, err = tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec(queryResetMigrations, pgs.tableName); err != nil {
+		return err
+	}
+	var version int
+	if err = tx.QueryRow(queryGetVersion, pgs.tableName).Scan(&version); err != nil {
+		return err
+	}
+	for v := version; v < last; v++ {
+		if _, err = tx.Exec(pgs.getQuery(migrations[v])); err != nil {
+			return fmt.Errorf("migration %d error: %s", v+1, err)
+		}
+		if _, err = tx.Exec(queryAddMigration, pgs.tableName, v+1); err != nil {
+			return err
+		}
+		log.Printf("table %s migrated to version %d", pgs.tableName, v+1)
+	}
+	return tx.Commit()
+}
+
+// finish record stats of crawl, crawl is finished
+// if it has no urls in flight
+func (pgs *PGStorage) finish() error {
+	rws, err := pgs.db.Query(pgs.getQuery(queryCrawlStats), pgs.CrawlID)
 	if err != nil {
-		log.Printf("set status error: %#v", err)
+		return err
 	}
+	defer rws.Close()
+
+	stats := make(map[string]int64)
+	for rws.Next() {
+		var sts Status
+		var n, size int64
+		if err = rws.Scan(&sts, &n, &size); err != nil {
+			return err
+		}
+		stats[statusNames[sts]] = n
+		stats["bytes"] += size
+	}
+	if err = rws.Err(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	_, err = pgs.db.Exec(queryFinishCrawl, pgs.CrawlID, string(b), stats[statusNames[InFlightStatus]] == 0)
 	return err
 }
 
+func isUndefinedTable(err error) bool {
+	pgerr, ok := err.(*pq.Error)
+	return ok && pgerr.Code == errUndefinedTable
+}
+
+// SetStatus buffer status of concret url
+func (pgs *PGStorage) SetStatus(url string, i Item) error {
+	pgs.buf.Set(url, i)
+	return nil
+}
+
+// write copy statuses to temp table and merge it to the table
+func (pgs *PGStorage) write(items map[string]Item) error {
+	tx, err := pgs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(pgs.getQuery(queryCreateStage)); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(pq.CopyIn(stageTable, "crawl_id", "url", "type", "status", "depth", "attempts",
+		"last_error", "http_status", "redirect", "lastmod", "etag", "modified", "hash",
+		"content_type", "size", "parent"))
+	if err != nil {
+		return err
+	}
+	for url, i := range items {
+		_, err = stmt.Exec(pgs.CrawlID, url, i.itype, i.status, i.depth, i.attempts, i.lastErr, i.httpStatus,
+			i.redirect, i.lastMod, i.etag, i.modified, i.hash, i.contentType, i.size, i.parent)
+		if err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err = stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err = stmt.Close(); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(pgs.getQuery(queryMergeStage)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (pgs *PGStorage) getQuery(tpl string) string {
 	return fmt.Sprintf(tpl, pgs.tableName)
 }
@@ -0,0 +1,143 @@
+package crawler_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chapsuk/crawler"
+)
+
+// pgTable return connection string of test database and host of new
+// table, test is skipped if CRAWLER_TEST_PG is not set
+func pgTable(t *testing.T) (string, string, *sql.DB) {
+	conn := os.Getenv("CRAWLER_TEST_PG")
+	if conn == "" {
+		t.Skip("CRAWLER_TEST_PG is not set")
+	}
+	db, err := sql.Open("postgres", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := fmt.Sprintf("test-%d.com", time.Now().UnixNano())
+	return conn, host, db
+}
+
+func dropPGTable(db *sql.DB, host string) {
+	db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, host))
+	db.Exec(`DELETE FROM crawls WHERE host = $1`, host)
+	db.Exec(`DELETE FROM schema_migrations WHERE table_name = $1`, host)
+	db.Close()
+}
+
+func pgVersions(t *testing.T, db *sql.DB, host string) []int {
+	rws, err := db.Query(`SELECT version FROM schema_migrations WHERE table_name = $1 ORDER BY applied, version`, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rws.Close()
+	var res []int
+	for rws.Next() {
+		var v int
+		if err = rws.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+func TestPGMigrations(t *testing.T) {
+	conn, host, db := pgTable(t)
+	defer dropPGTable(db, host)
+
+	var expected []int
+	for v := 1; v <= crawler.MigrationsCount; v++ {
+		expected = append(expected, v)
+	}
+	// the second start must not apply migrations again
+	for n := 0; n < 2; n++ {
+		pgs, err := crawler.NewPGStorage(conn, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = pgs.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if v := pgVersions(t, db, host); !reflect.DeepEqual(v, expected) {
+			t.Fatalf("start %d: expected versions %v, gotten %v", n+1, expected, v)
+		}
+	}
+}
+
+func TestPGMigrateFromVersion3(t *testing.T) {
+	conn, host, db := pgTable(t)
+	defer dropPGTable(db, host)
+
+	if err := crawler.MigratePG(conn, host, 3); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec(fmt.Sprintf(`INSERT INTO "%s" (url, type, status) VALUES ($1, $2, $3)`, host),
+		"http://"+host+"/", int(crawler.PageType), int(crawler.SavedStatus))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pgs, err := crawler.NewPGStorage(conn, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := pgVersions(t, db, host); len(v) != crawler.MigrationsCount {
+		t.Errorf("expected %d versions, gotten %v", crawler.MigrationsCount, v)
+	}
+	state, err := pgs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pgs.CrawlID == 0 {
+		t.Error("expected statuses moved to crawl")
+	}
+	if !state.IsSaved("http://" + host + "/") {
+		t.Error("expected status of version 3 table is loaded")
+	}
+
+	// statuses are merged by (crawl_id, url) key
+	state.MarkAsInFlight("http://"+host+"/a", crawler.PageType, 1)
+	if err = pgs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err = db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s" WHERE crawl_id = $1`, host), pgs.CrawlID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 statuses of crawl, gotten %d", n)
+	}
+}
+
+func TestPGLoadMissingTable(t *testing.T) {
+	conn, host, db := pgTable(t)
+	defer dropPGTable(db, host)
+
+	pgs, err := crawler.NewPGStorage(conn, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pgs.Close()
+	if _, err = db.Exec(fmt.Sprintf(`DROP TABLE "%s"`, host)); err != nil {
+		t.Fatal(err)
+	}
+	state, err := pgs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.IsEmpty() {
+		t.Error("expected empty state")
+	}
+	if v := pgVersions(t, db, host); len(v) != crawler.MigrationsCount {
+		t.Errorf("expected table migrated again, gotten versions %v", v)
+	}
+}
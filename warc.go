@@ -0,0 +1,224 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWARCSize is size of WARC file after which next file is started
+	DefaultWARCSize = 1 << 30
+
+	warcVersion = "WARC/1.1"
+	warcOpenExt = ".open"
+)
+
+// WARCWriter write WARC/1.1 records to rotating .warc.gz files,
+// every record is separate gzip member. Files being written have
+// .open suffix which is removed on rotation and close.
+// WARCWriter is safe for concurrent use.
+type WARCWriter struct {
+	dir     string
+	prefix  string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	name string
+	size int64
+	seq  int
+}
+
+// NewWARCWriter return writer of files dir/prefix-timestamp-seq.warc.gz,
+// next file is started when size of current one reaches maxSize,
+// zero maxSize is DefaultWARCSize
+func NewWARCWriter(dir, prefix string, maxSize int64) (*WARCWriter, error) {
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultWARCSize
+	}
+	if prefix == "" {
+		prefix = "crawler"
+	}
+	return &WARCWriter{
+		dir:     dir,
+		prefix:  prefix,
+		maxSize: maxSize,
+	}, nil
+}
+
+// WriteResponse write request, response and metadata records of
+// response with body, links are listed as outlinks in metadata.
+// Response headers are written as received by client, so bodies
+// decompressed by transport have no Content-Encoding.
+func (w *WARCWriter) WriteResponse(res *http.Response, body []byte, links []string) error {
+	var req bytes.Buffer
+	if err := res.Request.Write(&req); err != nil {
+		return err
+	}
+
+	var resp bytes.Buffer
+	fmt.Fprintf(&resp, "HTTP/%d.%d %s\r\n", res.ProtoMajor, res.ProtoMinor, res.Status)
+	res.Header.Write(&resp)
+	resp.WriteString("\r\n")
+	resp.Write(body)
+
+	var meta bytes.Buffer
+	for _, l := range links {
+		fmt.Fprintf(&meta, "outlink: %s\r\n", l)
+	}
+
+	uri := res.Request.URL.String()
+	date := time.Now().UTC().Format(time.RFC3339)
+	respID := warcRecordID()
+
+	var b bytes.Buffer
+	err := writeWARCRecord(&b, []string{
+		"WARC-Type", "response",
+		"WARC-Record-ID", respID,
+		"WARC-Date", date,
+		"WARC-Target-URI", uri,
+		"WARC-Payload-Digest", warcDigest(body),
+		"Content-Type", "application/http;msgtype=response",
+	}, resp.Bytes())
+	if err != nil {
+		return err
+	}
+	err = writeWARCRecord(&b, []string{
+		"WARC-Type", "request",
+		"WARC-Record-ID", warcRecordID(),
+		"WARC-Date", date,
+		"WARC-Target-URI", uri,
+		"WARC-Concurrent-To", respID,
+		"Content-Type", "application/http;msgtype=request",
+	}, req.Bytes())
+	if err != nil {
+		return err
+	}
+	err = writeWARCRecord(&b, []string{
+		"WARC-Type", "metadata",
+		"WARC-Record-ID", warcRecordID(),
+		"WARC-Date", date,
+		"WARC-Target-URI", uri,
+		"WARC-Concurrent-To", respID,
+		"Content-Type", "application/warc-fields",
+	}, meta.Bytes())
+	if err != nil {
+		return err
+	}
+	return w.write(b.Bytes())
+}
+
+// Close finish current file
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeFile()
+}
+
+// write gzipped records to current file and rotate it if needed
+func (w *WARCWriter) write(records []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		if err := w.openFile(); err != nil {
+			return err
+		}
+	}
+	n, err := w.f.Write(records)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if w.size >= w.maxSize {
+		return w.closeFile()
+	}
+	return nil
+}
+
+// openFile create next file and write warcinfo record
+func (w *WARCWriter) openFile() error {
+	w.seq++
+	w.name = fmt.Sprintf("%s-%s-%05d.warc.gz", w.prefix, time.Now().UTC().Format("20060102150405"), w.seq)
+	f, err := os.Create(filepath.Join(w.dir, w.name+warcOpenExt))
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	err = writeWARCRecord(&b, []string{
+		"WARC-Type", "warcinfo",
+		"WARC-Record-ID", warcRecordID(),
+		"WARC-Date", time.Now().UTC().Format(time.RFC3339),
+		"WARC-Filename", w.name,
+		"Content-Type", "application/warc-fields",
+	}, []byte("software: crawler\r\nformat: WARC File Format 1.1\r\n"))
+	if err == nil {
+		_, err = f.Write(b.Bytes())
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f = f
+	w.size = int64(b.Len())
+	return nil
+}
+
+func (w *WARCWriter) closeFile() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(w.dir, w.name)
+	return os.Rename(path+warcOpenExt, path)
+}
+
+// writeWARCRecord write record with headers as name, value pairs
+// as separate gzip member
+func writeWARCRecord(out io.Writer, headers []string, block []byte) error {
+	gz := gzip.NewWriter(out)
+	var b bytes.Buffer
+	b.WriteString(warcVersion + "\r\n")
+	for i := 0; i+1 < len(headers); i += 2 {
+		fmt.Fprintf(&b, "%s: %s\r\n", headers[i], headers[i+1])
+	}
+	fmt.Fprintf(&b, "WARC-Block-Digest: %s\r\n", warcDigest(block))
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(block))
+	b.Write(block)
+	b.WriteString("\r\n\r\n")
+	if _, err := gz.Write(b.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func warcDigest(b []byte) string {
+	h := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(h[:])
+}
+
+// warcRecordID return random uuid urn
+func warcRecordID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
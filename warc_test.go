@@ -0,0 +1,114 @@
+package crawler_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/chapsuk/crawler"
+)
+
+func TestWARCWriter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello")
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := crawler.NewWARCWriter(dir, "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := http.Get(srv.URL + "/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err = w.WriteResponse(res, body, []string{srv.URL + "/b"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Fatalf("expected 2 rotated files, gotten %v", files)
+	}
+	for _, name := range files {
+		if !strings.HasSuffix(name, ".warc.gz") {
+			t.Errorf("unexpected file name %s", name)
+		}
+		types := readWARCTypes(t, name)
+		expected := "warcinfo response request metadata"
+		if strings.Join(types, " ") != expected {
+			t.Errorf("expected records %q, gotten %q", expected, types)
+		}
+	}
+}
+
+// readWARCTypes return WARC-Type of records and check their lengths
+func readWARCTypes(t *testing.T, name string) []string {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(gz)
+
+	var types []string
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return types
+		}
+		if line != "WARC/1.1\r\n" {
+			t.Fatalf("expected version line, gotten %q", line)
+		}
+		length := -1
+		for {
+			line, err = r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\r\n" {
+				break
+			}
+			kv := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+			switch kv[0] {
+			case "WARC-Type":
+				types = append(types, kv[1])
+			case "Content-Length":
+				length, _ = strconv.Atoi(kv[1])
+			}
+		}
+		block := make([]byte, length+4)
+		if _, err = io.ReadFull(r, block); err != nil {
+			t.Fatal(err)
+		}
+		if string(block[length:]) != "\r\n\r\n" {
+			t.Fatalf("bad record end %q", block[length:])
+		}
+	}
+}